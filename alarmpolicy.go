@@ -0,0 +1,196 @@
+package jointechparser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AlarmChannel tells over which channels the device sends an alarm (P40)
+type AlarmChannel uint8
+
+const (
+	AlarmOff AlarmChannel = iota
+	AlarmGPRS
+	AlarmSMS
+	AlarmGPRSAndSMS
+)
+
+// AlarmType is index of the alarm switch in P40 command
+type AlarmType int
+
+const (
+	RopeCutAlarm AlarmType = iota
+	IllegalCardAlarm
+	LongUnlockAlarm
+	WrongPasswordAlarm
+	VibrationAlarm // Disabled in JT701D firmware
+	EnterFenceAlarm
+	ExitFenceAlarm
+	LowBatteryAlarm
+	CoverOpenAlarm
+	MotorStuckAlarm
+	alarmTypes
+)
+
+func (a AlarmType) String() string {
+	switch a {
+	case RopeCutAlarm:
+		return "RopeCutAlarm"
+	case IllegalCardAlarm:
+		return "IllegalCardAlarm"
+	case LongUnlockAlarm:
+		return "LongUnlockAlarm"
+	case WrongPasswordAlarm:
+		return "WrongPasswordAlarm"
+	case VibrationAlarm:
+		return "VibrationAlarm"
+	case EnterFenceAlarm:
+		return "EnterFenceAlarm"
+	case ExitFenceAlarm:
+		return "ExitFenceAlarm"
+	case LowBatteryAlarm:
+		return "LowBatteryAlarm"
+	case CoverOpenAlarm:
+		return "CoverOpenAlarm"
+	case MotorStuckAlarm:
+		return "MotorStuckAlarm"
+	}
+	return fmt.Sprintf("<unknown alarm: %d>", int(a))
+}
+
+// AlarmPolicy holds alarm switches (P40), low battery alarm threshold (P61) and long-time unlocking alarm duration (P38)
+type AlarmPolicy struct {
+	Channels            [alarmTypes]AlarmChannel // Alarm channels indexed by AlarmType
+	LowBatteryThreshold uint8                    // LowBattery event fires below this battery level in percent [0~90]
+	LongUnlockMinutes   uint16                   // LongTimeUnlocking event fires after the rope is out this long [3~180]
+}
+
+// DefaultAlarmPolicy returns factory default alarm policy as described in the JT701D protocol manual
+func DefaultAlarmPolicy() AlarmPolicy {
+	p := AlarmPolicy{LowBatteryThreshold: 30, LongUnlockMinutes: 120}
+	for a := range p.Channels {
+		p.Channels[a] = AlarmGPRS
+	}
+	return p
+}
+
+// Validate checks policy against value ranges given by the JT701D protocol manual
+func (p *AlarmPolicy) Validate() error {
+	for a, c := range p.Channels {
+		if c > AlarmGPRSAndSMS {
+			return fmt.Errorf("invalid channel %d for %v", c, AlarmType(a))
+		}
+	}
+	if p.LowBatteryThreshold > 90 {
+		return fmt.Errorf("invalid low battery threshold, want 0-90, got %d", p.LowBatteryThreshold)
+	}
+	if p.LongUnlockMinutes < 3 || p.LongUnlockMinutes > 180 {
+		return fmt.Errorf("invalid long-time unlocking duration, want 3-180, got %d", p.LongUnlockMinutes)
+	}
+	return nil
+}
+
+// Commands returns P40, P61 and P38 set commands
+func (p *AlarmPolicy) Commands() ([]Command, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return []Command{p.channelsCommand(), p.lowBatteryCommand(), p.longUnlockCommand()}, nil
+}
+
+// DiffAlarmPolicy returns only set commands needed to turn current policy into desired one
+func DiffAlarmPolicy(current, desired AlarmPolicy) ([]Command, error) {
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+	var cmds []Command
+	if current.Channels != desired.Channels {
+		cmds = append(cmds, desired.channelsCommand())
+	}
+	if current.LowBatteryThreshold != desired.LowBatteryThreshold {
+		cmds = append(cmds, desired.lowBatteryCommand())
+	}
+	if current.LongUnlockMinutes != desired.LongUnlockMinutes {
+		cmds = append(cmds, desired.longUnlockCommand())
+	}
+	return cmds, nil
+}
+
+// QueryAlarmPolicy returns commands querying the alarm policy
+func QueryAlarmPolicy() []Command {
+	return []Command{NewCommand("P40", "0"), NewCommand("P61", "0"), NewCommand("P38", "0")}
+}
+
+func (p *AlarmPolicy) channelsCommand() Command {
+	params := make([]string, 0, 1+alarmTypes)
+	params = append(params, "1")
+	for _, c := range p.Channels {
+		params = append(params, strconv.Itoa(int(c)))
+	}
+	return NewCommand("P40", params...)
+}
+
+func (p *AlarmPolicy) lowBatteryCommand() Command {
+	return NewCommand("P61", "1", strconv.Itoa(int(p.LowBatteryThreshold)))
+}
+
+func (p *AlarmPolicy) longUnlockCommand() Command {
+	return NewCommand("P38", "1", strconv.Itoa(int(p.LongUnlockMinutes)))
+}
+
+// ParseAlarmPolicy builds AlarmPolicy from P40, P61 and P38 responses
+func ParseAlarmPolicy(rs ...Response) (AlarmPolicy, error) {
+	p := AlarmPolicy{}
+	for _, r := range rs {
+		if err := p.Apply(r); err != nil {
+			return AlarmPolicy{}, err
+		}
+	}
+	return p, nil
+}
+
+// Apply updates policy with P40, P61 or P38 response
+func (p *AlarmPolicy) Apply(r Response) error {
+	switch r.Word {
+	case "P40":
+		// (8130630001,P40,1,1,1,1,1,1,1,1,1,1,0,0,0,0), trailing customized alarm types are ignored
+		if err := r.expect("P40", int(alarmTypes)); err != nil {
+			return err
+		}
+		var channels [alarmTypes]AlarmChannel
+		for a := range channels {
+			c, err := r.uint(a, 8)
+			if err != nil {
+				return err
+			}
+			if AlarmChannel(c) > AlarmGPRSAndSMS {
+				return fmt.Errorf("invalid channel %d for %v", c, AlarmType(a))
+			}
+			channels[a] = AlarmChannel(c)
+		}
+		p.Channels = channels
+	case "P61":
+		// (8130630001,P61,30)
+		if err := r.expect("P61", 1); err != nil {
+			return err
+		}
+		threshold, err := r.uint(0, 8)
+		if err != nil {
+			return err
+		}
+		p.LowBatteryThreshold = uint8(threshold)
+	case "P38":
+		// (8130630001,P38,120)
+		if err := r.expect("P38", 1); err != nil {
+			return err
+		}
+		minutes, err := r.uint(0, 16)
+		if err != nil {
+			return err
+		}
+		p.LongUnlockMinutes = uint16(minutes)
+	default:
+		return fmt.Errorf("unexpected command word %s for alarm policy", r.Word)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAlarmPolicy(t *testing.T) {
+	switches, _ := ParseResponse([]byte("(8130630001,P40,1,1,1,1,1,1,1,1,1,1,0,0,0,0)"))
+	battery, _ := ParseResponse([]byte("(8130630001,P61,30)"))
+	unlock, _ := ParseResponse([]byte("(8130630001,P38,120)"))
+
+	p, err := ParseAlarmPolicy(switches, battery, unlock)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAlarmPolicy(), p)
+
+	bad, _ := ParseResponse([]byte("(8130630001,P40,4,1,1,1,1,1,1,1,1,1)"))
+	_, err = ParseAlarmPolicy(bad)
+	assert.Error(t, err)
+	short, _ := ParseResponse([]byte("(8130630001,P40,1,1,1)"))
+	_, err = ParseAlarmPolicy(short)
+	assert.Error(t, err)
+}
+
+func TestAlarmPolicyCommands(t *testing.T) {
+	p := DefaultAlarmPolicy()
+	p.Channels[RopeCutAlarm] = AlarmGPRSAndSMS
+	p.Channels[IllegalCardAlarm] = AlarmOff
+
+	cmds, err := p.Commands()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"(P40,1,3,0,1,1,1,1,1,1,1,1)", "(P61,1,30)", "(P38,1,120)"},
+		[]string{cmds[0].String(), cmds[1].String(), cmds[2].String()})
+
+	cmds, err = DiffAlarmPolicy(DefaultAlarmPolicy(), p)
+	assert.NoError(t, err)
+	assert.Len(t, cmds, 1)
+	assert.Equal(t, "P40", cmds[0].Word)
+
+	p.LongUnlockMinutes = 181
+	_, err = DiffAlarmPolicy(DefaultAlarmPolicy(), p)
+	assert.Error(t, err)
+}
+
+func TestAlarmTypeString(t *testing.T) {
+	assert.Equal(t, "MotorStuckAlarm", MotorStuckAlarm.String())
+	assert.Equal(t, "<unknown alarm: 10>", AlarmType(10).String())
+}
@@ -0,0 +1,155 @@
+package jointechparser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// escaped holds JT701D escape sequences used inside ASCII command data, 0x3D 0x00 must be restored last
+var escaped = []struct {
+	seq []byte
+	raw []byte
+}{
+	{[]byte{0x3D, 0x15}, []byte{0x28}},
+	{[]byte{0x3D, 0x14}, []byte{0x29}},
+	{[]byte{0x3D, 0x11}, []byte{0x2C}},
+	{[]byte{0x3D, 0x00}, []byte{0x3D}},
+}
+
+// Command represents an ASCII command sent by the platform to the device, e.g. (P24,1,10,1,area10)
+type Command struct {
+	Word   string   // Command word such as P24
+	Params []string // Parameters separated by comma
+}
+
+// NewCommand returns a Command with given command word and parameters
+func NewCommand(word string, params ...string) Command {
+	return Command{Word: word, Params: params}
+}
+
+// String returns command in the wire format
+func (c Command) String() string {
+	if len(c.Params) == 0 {
+		return "(" + c.Word + ")"
+	}
+	return "(" + c.Word + "," + strings.Join(c.Params, ",") + ")"
+}
+
+// Bytes returns command in the wire format as a slice of bytes
+func (c Command) Bytes() []byte {
+	return []byte(c.String())
+}
+
+// Response represents an ASCII command response reported by the device, e.g. (8130630001,P24,10,1,area10)
+type Response struct {
+	TerminalID string   // JointTech assigned ID in decimal format
+	Word       string   // Command word such as P24
+	Params     []string // Parameters following the command word with escape characters restored
+}
+
+// ParseResponse takes a single response packet including the enclosing brackets and returns Response
+func ParseResponse(bs []byte) (Response, error) {
+	if len(bs) < 2 || bs[0] != 0x28 || bs[len(bs)-1] != 0x29 {
+		return Response{}, fmt.Errorf("%q is not a JT command response", bs)
+	}
+
+	fields := bytes.Split(bs[1:len(bs)-1], []byte{0x2C})
+	if len(fields) < 2 {
+		return Response{}, fmt.Errorf("%q is missing command word", bs)
+	}
+	if len(fields[0]) != 10 {
+		return Response{}, fmt.Errorf("invalid terminal ID %q, want 10 digits", fields[0])
+	}
+	if !isCommandWord(fields[1]) {
+		return Response{}, fmt.Errorf("invalid command word %q", fields[1])
+	}
+
+	r := Response{
+		TerminalID: string(fields[0]),
+		Word:       string(fields[1]),
+		Params:     make([]string, 0, len(fields)-2),
+	}
+	for _, f := range fields[2:] {
+		r.Params = append(r.Params, string(unescape(f)))
+	}
+	return r, nil
+}
+
+// isCommandWord reports whether bs looks like a command word such as P01 or P98
+func isCommandWord(bs []byte) bool {
+	if len(bs) != 3 || bs[0] != 'P' {
+		return false
+	}
+	return bs[1] >= '0' && bs[1] <= '9' && bs[2] >= '0' && bs[2] <= '9'
+}
+
+func unescape(bs []byte) []byte {
+	if bytes.IndexByte(bs, 0x3D) < 0 {
+		return bs
+	}
+	out := bs
+	for _, e := range escaped {
+		out = bytes.ReplaceAll(out, e.seq, e.raw)
+	}
+	return out
+}
+
+// expect returns an error when response is not for the given command word or has less than n parameters
+func (r Response) expect(word string, n int) error {
+	if r.Word != word {
+		return fmt.Errorf("unexpected command word %s, want %s", r.Word, word)
+	}
+	if len(r.Params) < n {
+		return fmt.Errorf("%s response has %d parameters, want at least %d", word, len(r.Params), n)
+	}
+	return nil
+}
+
+// uint parses parameter at index n as unsigned integer of given bit size
+func (r Response) uint(n int, bitSize int) (uint64, error) {
+	if n >= len(r.Params) {
+		return 0, fmt.Errorf("%s response is missing parameter %d", r.Word, n)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(r.Params[n]), 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s parameter %d, %v", r.Word, n, err)
+	}
+	return v, nil
+}
+
+// int parses parameter at index n as signed integer of given bit size
+func (r Response) int(n int, bitSize int) (int64, error) {
+	if n >= len(r.Params) {
+		return 0, fmt.Errorf("%s response is missing parameter %d", r.Word, n)
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(r.Params[n]), 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s parameter %d, %v", r.Word, n, err)
+	}
+	return v, nil
+}
+
+// bool parses parameter at index n which is either 0 or 1
+func (r Response) bool(n int) (bool, error) {
+	v, err := r.uint(n, 8)
+	if err != nil {
+		return false, err
+	}
+	if v > 1 {
+		return false, fmt.Errorf("%s parameter %d, want 0 or 1, got %d", r.Word, n, v)
+	}
+	return v == 1, nil
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
@@ -0,0 +1,49 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandString(t *testing.T) {
+	assert.Equal(t, "(P24,1,10,1,area10)", NewCommand("P24", "1", "10", "1", "area10").String())
+	assert.Equal(t, "(P31)", NewCommand("P31").String())
+	assert.Equal(t, []byte("(P01)"), NewCommand("P01").Bytes())
+}
+
+func TestParseResponse(t *testing.T) {
+	r, err := ParseResponse([]byte("(8130630001,P24,10,1,area10)"))
+	assert.NoError(t, err)
+	assert.Equal(t, Response{TerminalID: "8130630001", Word: "P24", Params: []string{"10", "1", "area10"}}, r)
+
+	r, err = ParseResponse([]byte("(8130630001,P31)"))
+	assert.NoError(t, err)
+	assert.Equal(t, "P31", r.Word)
+	assert.Empty(t, r.Params)
+}
+
+func TestParseResponseUnescape(t *testing.T) {
+	r, err := ParseResponse([]byte{'(', '8', '1', '3', '0', '6', '3', '0', '0', '0', '1', ',', 'P', '6', '5', ',',
+		'a', 0x3D, 0x15, 'b', 0x3D, 0x14, 0x3D, 0x11, 0x3D, 0x00, 0x15, ')'})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a(b),=\x15"}, r.Params)
+}
+
+func TestParseResponseFailure(t *testing.T) {
+	for _, s := range []string{"", "(8000620011,@JT)", "(8130630001)", "(813063,P01)", "8130630001,P01", "(7500313620,1,076,WLNET,5)"} {
+		_, err := ParseResponse([]byte(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDecodeCollectsResponses(t *testing.T) {
+	byteData := []byte("(8130630001,P31)(8000620011,@JT)(8130630001,P30,1)")
+	decoded, err := Decode(&byteData)
+	assert.NoError(t, err)
+	assert.True(t, decoded.ContainsHealthcheck)
+	assert.Equal(t, []Response{
+		{TerminalID: "8130630001", Word: "P31", Params: []string{}},
+		{TerminalID: "8130630001", Word: "P30", Params: []string{"1"}},
+	}, decoded.Responses)
+}
@@ -0,0 +1,349 @@
+package jointechparser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// P98,6 debug stream types
+const (
+	DebugStop uint8 = 0
+	DebugNMEA uint8 = 1
+	DebugAT   uint8 = 6
+)
+
+// StartATTrace returns P98,6,6 command streaming AT command flow of the communication module
+func StartATTrace() Command {
+	return NewCommand("P98", "6", strconv.Itoa(int(DebugAT)))
+}
+
+// StartNMEATrace returns P98,6,1 command streaming GPS NMEA data
+func StartNMEATrace() Command {
+	return NewCommand("P98", "6", strconv.Itoa(int(DebugNMEA)))
+}
+
+// StopTrace returns P98,6,0 command stopping AT and NMEA stream
+func StopTrace() Command {
+	return NewCommand("P98", "6", strconv.Itoa(int(DebugStop)))
+}
+
+// ParseTraceReply parses P98,6 response and returns the stream type the device switched to
+func ParseTraceReply(r Response) (uint8, error) {
+	// (8130630001,P98,6,6)
+	if err := r.expect("P98", 2); err != nil {
+		return 0, err
+	}
+	if r.Params[0] != "6" {
+		return 0, fmt.Errorf("unexpected P98 instruction %s, want 6", r.Params[0])
+	}
+	mode, err := r.uint(1, 8)
+	if err != nil {
+		return 0, err
+	}
+	if uint8(mode) != DebugStop && uint8(mode) != DebugNMEA && uint8(mode) != DebugAT {
+		return 0, fmt.Errorf("invalid P98,6 stream type %d", mode)
+	}
+	return uint8(mode), nil
+}
+
+// ATExchange is an AT command sent to the communication module together with its response lines
+type ATExchange struct {
+	Command  string   // e.g. AT+CSQ
+	Response []string // Information lines, e.g. +CSQ: 20,99
+	Result   string   // Final result code OK, ERROR, +CME ERROR: 10, empty when the stream ended before it
+}
+
+// NMEASentence is a parsed NMEA sentence
+type NMEASentence interface {
+	SentenceType() string
+}
+
+// GGA is GPS fix data sentence
+type GGA struct {
+	Talker     string  // GP, GN, BD...
+	Time       string  // hhmmss.ss UTC
+	Lat        float64 // Decimal degrees, negative for south
+	Lng        float64 // Decimal degrees, negative for west
+	Quality    uint8   // 0 no fix, 1 GPS fix, 2 DGPS fix
+	Satellites uint8   // Satellites used for fix
+	HDOP       float64
+	Altitude   float64 // Meters above mean sea level
+}
+
+// SentenceType implements NMEASentence
+func (g *GGA) SentenceType() string { return "GGA" }
+
+// RMC is recommended minimum navigation data sentence
+type RMC struct {
+	Talker string
+	Time   string // hhmmss.ss UTC
+	Date   string // ddmmyy
+	Valid  bool   // A means valid, V means warning
+	Lat    float64
+	Lng    float64
+	Speed  float64 // Speed over ground in knots
+	Course float64 // Course over ground in degrees
+}
+
+// SentenceType implements NMEASentence
+func (r *RMC) SentenceType() string { return "RMC" }
+
+// SatelliteInfo is a single satellite reported by GSV sentence
+type SatelliteInfo struct {
+	PRN       int
+	Elevation int // Degrees, -1 when not reported
+	Azimuth   int // Degrees, -1 when not reported
+	SNR       int // dB-Hz, -1 when satellite is not tracked
+}
+
+// GSV is satellites in view sentence, a full view is spread over Total sentences
+type GSV struct {
+	Talker     string
+	Total      int // Number of GSV sentences in this cycle
+	Number     int // Number of this sentence
+	InView     int // Satellites in view
+	Satellites []SatelliteInfo
+}
+
+// SentenceType implements NMEASentence
+func (g *GSV) SentenceType() string { return "GSV" }
+
+// DebugCapture is P98,6 stream split to AT command exchanges and NMEA sentences
+type DebugCapture struct {
+	Lines       []string       // Every received line in order
+	AT          []ATExchange   // AT command exchanges in order
+	Unsolicited []string       // Module result codes not belonging to any command, e.g. +CREG: 1
+	NMEA        []NMEASentence // Supported NMEA sentences with valid checksum in order
+	Invalid     []string       // NMEA lines which could not be parsed or failed checksum
+}
+
+// ParseDebugStream reads P98,6 stream until EOF
+func ParseDebugStream(r io.Reader) (*DebugCapture, error) {
+	c := &DebugCapture{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		c.AddLine(sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading debug stream, %v", err)
+	}
+	return c, nil
+}
+
+// AddLine adds a single stream line to the capture
+func (c *DebugCapture) AddLine(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	c.Lines = append(c.Lines, line)
+	line = strings.TrimSpace(line)
+
+	switch {
+	case line[0] == '$':
+		s, err := ParseNMEA(line)
+		if err != nil {
+			c.Invalid = append(c.Invalid, line)
+			return
+		}
+		if s != nil {
+			c.NMEA = append(c.NMEA, s)
+		}
+	case len(line) >= 2 && strings.EqualFold(line[:2], "AT"):
+		c.AT = append(c.AT, ATExchange{Command: line})
+	default:
+		last := len(c.AT) - 1
+		if last < 0 || c.AT[last].Result != "" {
+			c.Unsolicited = append(c.Unsolicited, line)
+			return
+		}
+		if isATResult(line) {
+			c.AT[last].Result = line
+			return
+		}
+		c.AT[last].Response = append(c.AT[last].Response, line)
+	}
+}
+
+// Satellites returns satellites of the latest GSV cycle of every talker
+func (c *DebugCapture) Satellites() []SatelliteInfo {
+	var sats []SatelliteInfo
+	cycles := make(map[string][]SatelliteInfo)
+	for _, s := range c.NMEA {
+		gsv, ok := s.(*GSV)
+		if !ok {
+			continue
+		}
+		if gsv.Number == 1 {
+			cycles[gsv.Talker] = nil
+		}
+		cycles[gsv.Talker] = append(cycles[gsv.Talker], gsv.Satellites...)
+	}
+	talkers := make([]string, 0, len(cycles))
+	for t := range cycles {
+		talkers = append(talkers, t)
+	}
+	sort.Strings(talkers)
+	for _, t := range talkers {
+		sats = append(sats, cycles[t]...)
+	}
+	return sats
+}
+
+// WriteTo writes captured lines to w, implements io.WriterTo
+func (c *DebugCapture) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, l := range c.Lines {
+		m, err := io.WriteString(w, l+"\r\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Save writes captured lines to a file which can be loaded again with ParseDebugStream
+func (c *DebugCapture) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func isATResult(line string) bool {
+	switch line {
+	case "OK", "ERROR", "NO CARRIER", "BUSY", "NO ANSWER", "NO DIALTONE", "SEND OK", "SEND FAIL":
+		return true
+	}
+	return strings.HasPrefix(line, "+CME ERROR") || strings.HasPrefix(line, "+CMS ERROR")
+}
+
+// ParseNMEA parses GGA, RMC and GSV sentences, other valid sentences return nil sentence and nil error
+func ParseNMEA(line string) (NMEASentence, error) {
+	line = strings.TrimSpace(line)
+	if len(line) < 7 || line[0] != '$' {
+		return nil, fmt.Errorf("%q is not a NMEA sentence", line)
+	}
+	body := line[1:]
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		sum, err := strconv.ParseUint(body[star+1:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NMEA checksum in %q", line)
+		}
+		body = body[:star]
+		var calc byte
+		for i := 0; i < len(body); i++ {
+			calc ^= body[i]
+		}
+		if calc != byte(sum) {
+			return nil, fmt.Errorf("NMEA checksum mismatch in %q, want %02X", line, calc)
+		}
+	}
+
+	f := strings.Split(body, ",")
+	if len(f[0]) != 5 {
+		return nil, fmt.Errorf("invalid NMEA address %q", f[0])
+	}
+	talker, kind := f[0][:2], f[0][2:]
+	switch kind {
+	case "GGA":
+		if len(f) < 10 {
+			return nil, fmt.Errorf("GGA sentence has %d fields, want 10", len(f))
+		}
+		lat, err := nmeaCoordinate(f[2], f[3])
+		if err != nil {
+			return nil, err
+		}
+		lng, err := nmeaCoordinate(f[4], f[5])
+		if err != nil {
+			return nil, err
+		}
+		return &GGA{
+			Talker:     talker,
+			Time:       f[1],
+			Lat:        lat,
+			Lng:        lng,
+			Quality:    uint8(nmeaInt(f[6], 0)),
+			Satellites: uint8(nmeaInt(f[7], 0)),
+			HDOP:       nmeaFloat(f[8]),
+			Altitude:   nmeaFloat(f[9]),
+		}, nil
+	case "RMC":
+		if len(f) < 10 {
+			return nil, fmt.Errorf("RMC sentence has %d fields, want 10", len(f))
+		}
+		lat, err := nmeaCoordinate(f[3], f[4])
+		if err != nil {
+			return nil, err
+		}
+		lng, err := nmeaCoordinate(f[5], f[6])
+		if err != nil {
+			return nil, err
+		}
+		return &RMC{
+			Talker: talker,
+			Time:   f[1],
+			Valid:  f[2] == "A",
+			Lat:    lat,
+			Lng:    lng,
+			Speed:  nmeaFloat(f[7]),
+			Course: nmeaFloat(f[8]),
+			Date:   f[9],
+		}, nil
+	case "GSV":
+		if len(f) < 4 {
+			return nil, fmt.Errorf("GSV sentence has %d fields, want 4", len(f))
+		}
+		g := &GSV{Talker: talker, Total: nmeaInt(f[1], 0), Number: nmeaInt(f[2], 0), InView: nmeaInt(f[3], 0)}
+		// satellites come in groups of 4 fields, NMEA 4.1 may append a signal ID
+		for n := 4; n+3 < len(f); n += 4 {
+			g.Satellites = append(g.Satellites, SatelliteInfo{
+				PRN:       nmeaInt(f[n], 0),
+				Elevation: nmeaInt(f[n+1], -1),
+				Azimuth:   nmeaInt(f[n+2], -1),
+				SNR:       nmeaInt(f[n+3], -1),
+			})
+		}
+		return g, nil
+	}
+	return nil, nil
+}
+
+// nmeaCoordinate converts (d)ddmm.mmmm value and hemisphere to decimal degrees
+func nmeaCoordinate(value, hemisphere string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	deg, err := parseDegreesMinutes(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid NMEA coordinate %q, %v", value, err)
+	}
+	if hemisphere == "S" || hemisphere == "W" {
+		deg = -deg
+	}
+	return deg, nil
+}
+
+func nmeaInt(s string, def int) int {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func nmeaFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
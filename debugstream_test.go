@@ -0,0 +1,96 @@
+package jointechparser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const debugStream = "AT+CSQ\r\n" +
+	"+CSQ: 20,99\r\n" +
+	"\r\n" +
+	"OK\r\n" +
+	"+CREG: 1\r\n" +
+	"AT+CGATT?\r\n" +
+	"+CME ERROR: 10\r\n" +
+	"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n" +
+	"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\r\n" +
+	"$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39\r\n" +
+	"$GPGSV,2,1,08,01,40,083,46,02,17,308,41,12,07,344,39,14,22,228,45*75\r\n" +
+	"$GPGSV,2,2,08,32,,,,33,10,120,*42\r\n" +
+	"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48\r\n" +
+	"AT+QIOPEN=1,0\r\n"
+
+func TestTraceCommands(t *testing.T) {
+	assert.Equal(t, "(P98,6,6)", StartATTrace().String())
+	assert.Equal(t, "(P98,6,1)", StartNMEATrace().String())
+	assert.Equal(t, "(P98,6,0)", StopTrace().String())
+
+	r, _ := ParseResponse([]byte("(8130630001,P98,6,6)"))
+	mode, err := ParseTraceReply(r)
+	assert.NoError(t, err)
+	assert.Equal(t, DebugAT, mode)
+	r, _ = ParseResponse([]byte("(8130630001,P98,6,3)"))
+	_, err = ParseTraceReply(r)
+	assert.Error(t, err)
+}
+
+func TestParseDebugStream(t *testing.T) {
+	c, err := ParseDebugStream(strings.NewReader(debugStream))
+	assert.NoError(t, err)
+	assert.Len(t, c.Lines, 13)
+	assert.Equal(t, []ATExchange{
+		{Command: "AT+CSQ", Response: []string{"+CSQ: 20,99"}, Result: "OK"},
+		{Command: "AT+CGATT?", Result: "+CME ERROR: 10"},
+		{Command: "AT+QIOPEN=1,0"},
+	}, c.AT)
+	assert.Equal(t, []string{"+CREG: 1"}, c.Unsolicited)
+	assert.Len(t, c.NMEA, 4)
+	assert.Len(t, c.Invalid, 1)
+
+	gga := c.NMEA[0].(*GGA)
+	assert.Equal(t, "GP", gga.Talker)
+	assert.InDelta(t, 48.1173, gga.Lat, 0.0001)
+	assert.InDelta(t, 11.516667, gga.Lng, 0.0001)
+	assert.Equal(t, uint8(8), gga.Satellites)
+	assert.Equal(t, 545.4, gga.Altitude)
+
+	rmc := c.NMEA[1].(*RMC)
+	assert.True(t, rmc.Valid)
+	assert.Equal(t, "230394", rmc.Date)
+	assert.Equal(t, 22.4, rmc.Speed)
+
+	sats := c.Satellites()
+	assert.Len(t, sats, 6)
+	assert.Equal(t, SatelliteInfo{PRN: 1, Elevation: 40, Azimuth: 83, SNR: 46}, sats[0])
+	assert.Equal(t, SatelliteInfo{PRN: 32, Elevation: -1, Azimuth: -1, SNR: -1}, sats[4])
+	assert.Equal(t, SatelliteInfo{PRN: 33, Elevation: 10, Azimuth: 120, SNR: -1}, sats[5])
+}
+
+func TestDebugCaptureSave(t *testing.T) {
+	c, err := ParseDebugStream(strings.NewReader(debugStream))
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "capture.log")
+	assert.NoError(t, c.Save(path))
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	loaded, err := ParseDebugStream(f)
+	assert.NoError(t, err)
+	assert.Equal(t, c, loaded)
+}
+
+func TestParseNMEAFailure(t *testing.T) {
+	for _, s := range []string{"", "GPGGA,1*00", "$GPGGA,123519*ZZ", "$GPGGA,1,2,3*02", "$GPGGA,123519,48a7.038,N,01131.000,E,1,08,0.9,545.4"} {
+		_, err := ParseNMEA(s)
+		assert.Error(t, err, s)
+	}
+	s, err := ParseNMEA("$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39")
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+}
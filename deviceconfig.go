@@ -0,0 +1,237 @@
+package jointechparser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DeviceConfig holds device settings configured by P03, P04, P37, P39, P54, P62 and P63 commands
+type DeviceConfig struct {
+	DeepSleep             bool   // Enter deep sleep mode on low battery (P03)
+	DeepSleepBattery      uint8  // Battery level in percent entering deep sleep mode [5~90] (P03)
+	UploadInterval        uint16 // Data upload interval after wake up in seconds [5~600] (P04)
+	RTCInterval           uint16 // RTC timing wake up interval in minutes [5~1440] (P04)
+	GSensorThreshold      uint16 // Motion detection threshold in mg, 0 turns G-sensor off or [63~500] (P37)
+	WakeWorkTime          uint8  // Working time after wake up in minutes [3~10] (P39)
+	Tracking              bool   // Tracking mode, device keeps reporting without going to sleep (P54)
+	MileageSpeedThreshold uint32 // Mileage is not accumulated below this speed in km/h (P62,1)
+	GPSDriftOptimization  bool   // GPS static drift optimization (P63)
+}
+
+// DefaultDeviceConfig returns factory default settings as described in the JT701D protocol manual
+func DefaultDeviceConfig() DeviceConfig {
+	return DeviceConfig{
+		DeepSleep:             true,
+		DeepSleepBattery:      5,
+		UploadInterval:        60,
+		RTCInterval:           30,
+		GSensorThreshold:      126,
+		WakeWorkTime:          10,
+		MileageSpeedThreshold: 10,
+	}
+}
+
+// deviceSettings lists one entry per set command, in the order commands are sent
+var deviceSettings = []struct {
+	equal func(a, b *DeviceConfig) bool
+	cmd   func(c *DeviceConfig) Command
+}{
+	{
+		func(a, b *DeviceConfig) bool { return a.DeepSleep == b.DeepSleep && a.DeepSleepBattery == b.DeepSleepBattery },
+		func(c *DeviceConfig) Command {
+			return NewCommand("P03", "1", formatBool(c.DeepSleep), strconv.Itoa(int(c.DeepSleepBattery)))
+		},
+	},
+	{
+		func(a, b *DeviceConfig) bool { return a.UploadInterval == b.UploadInterval && a.RTCInterval == b.RTCInterval },
+		func(c *DeviceConfig) Command {
+			return NewCommand("P04", "1", strconv.Itoa(int(c.UploadInterval)), strconv.Itoa(int(c.RTCInterval)))
+		},
+	},
+	{
+		func(a, b *DeviceConfig) bool { return a.GSensorThreshold == b.GSensorThreshold },
+		func(c *DeviceConfig) Command { return NewCommand("P37", "1", strconv.Itoa(int(c.GSensorThreshold))) },
+	},
+	{
+		func(a, b *DeviceConfig) bool { return a.WakeWorkTime == b.WakeWorkTime },
+		func(c *DeviceConfig) Command { return NewCommand("P39", "1", strconv.Itoa(int(c.WakeWorkTime))) },
+	},
+	{
+		func(a, b *DeviceConfig) bool { return a.Tracking == b.Tracking },
+		func(c *DeviceConfig) Command { return NewCommand("P54", "1", formatBool(c.Tracking)) },
+	},
+	{
+		func(a, b *DeviceConfig) bool { return a.MileageSpeedThreshold == b.MileageSpeedThreshold },
+		func(c *DeviceConfig) Command {
+			return NewCommand("P62", "1", "1", formatUint(uint64(c.MileageSpeedThreshold)))
+		},
+	},
+	{
+		func(a, b *DeviceConfig) bool { return a.GPSDriftOptimization == b.GPSDriftOptimization },
+		func(c *DeviceConfig) Command { return NewCommand("P63", "1", formatBool(c.GPSDriftOptimization)) },
+	},
+}
+
+// Validate checks settings against value ranges given by the JT701D protocol manual
+func (c *DeviceConfig) Validate() error {
+	if c.DeepSleepBattery < 5 || c.DeepSleepBattery > 90 {
+		return fmt.Errorf("invalid deep sleep battery level, want 5-90, got %d", c.DeepSleepBattery)
+	}
+	if c.UploadInterval < 5 || c.UploadInterval > 600 {
+		return fmt.Errorf("invalid upload interval, want 5-600, got %d", c.UploadInterval)
+	}
+	if c.RTCInterval < 5 || c.RTCInterval > 1440 {
+		return fmt.Errorf("invalid RTC interval, want 5-1440, got %d", c.RTCInterval)
+	}
+	if c.GSensorThreshold != 0 && (c.GSensorThreshold < 63 || c.GSensorThreshold > 500) {
+		return fmt.Errorf("invalid G-sensor threshold, want 0 or 63-500, got %d", c.GSensorThreshold)
+	}
+	if c.WakeWorkTime < 3 || c.WakeWorkTime > 10 {
+		return fmt.Errorf("invalid wake up working time, want 3-10, got %d", c.WakeWorkTime)
+	}
+	return nil
+}
+
+// Commands returns set commands for all settings
+func (c *DeviceConfig) Commands() ([]Command, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	cmds := make([]Command, 0, len(deviceSettings))
+	for _, s := range deviceSettings {
+		cmds = append(cmds, s.cmd(c))
+	}
+	return cmds, nil
+}
+
+// Diff returns only set commands needed to turn current settings into desired ones
+func Diff(current, desired DeviceConfig) ([]Command, error) {
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+	var cmds []Command
+	for _, s := range deviceSettings {
+		if !s.equal(&current, &desired) {
+			cmds = append(cmds, s.cmd(&desired))
+		}
+	}
+	return cmds, nil
+}
+
+// QueryDeviceConfig returns commands querying all settings held by DeviceConfig
+func QueryDeviceConfig() []Command {
+	return []Command{
+		NewCommand("P03", "0"),
+		NewCommand("P04", "0"),
+		NewCommand("P37", "0"),
+		NewCommand("P39", "0"),
+		NewCommand("P54", "0"),
+		NewCommand("P62", "1", "0"),
+		NewCommand("P63", "0"),
+	}
+}
+
+// ParseDeviceConfig builds DeviceConfig from query responses, settings without response keep zero values
+func ParseDeviceConfig(rs ...Response) (DeviceConfig, error) {
+	c := DeviceConfig{}
+	for _, r := range rs {
+		if err := c.Apply(r); err != nil {
+			return DeviceConfig{}, err
+		}
+	}
+	return c, nil
+}
+
+// Apply updates settings with a query or set response
+func (c *DeviceConfig) Apply(r Response) error {
+	switch r.Word {
+	case "P03":
+		// (8130630001,P03,1,5)
+		if err := r.expect("P03", 2); err != nil {
+			return err
+		}
+		enabled, err := r.bool(0)
+		if err != nil {
+			return err
+		}
+		battery, err := r.uint(1, 8)
+		if err != nil {
+			return err
+		}
+		c.DeepSleep, c.DeepSleepBattery = enabled, uint8(battery)
+	case "P04":
+		// (8130630001,P04,60,30)
+		if err := r.expect("P04", 2); err != nil {
+			return err
+		}
+		upload, err := r.uint(0, 16)
+		if err != nil {
+			return err
+		}
+		rtc, err := r.uint(1, 16)
+		if err != nil {
+			return err
+		}
+		c.UploadInterval, c.RTCInterval = uint16(upload), uint16(rtc)
+	case "P37":
+		// (8130630001,P37,126,15), second parameter is customized and ignored
+		if err := r.expect("P37", 1); err != nil {
+			return err
+		}
+		threshold, err := r.uint(0, 16)
+		if err != nil {
+			return err
+		}
+		c.GSensorThreshold = uint16(threshold)
+	case "P39":
+		// (8130630001,P39,1,5), operation mode comes first
+		if err := r.expect("P39", 1); err != nil {
+			return err
+		}
+		minutes, err := r.uint(len(r.Params)-1, 8)
+		if err != nil {
+			return err
+		}
+		c.WakeWorkTime = uint8(minutes)
+	case "P54":
+		// (8130630001,P54,1,0), operation mode comes first
+		if err := r.expect("P54", 1); err != nil {
+			return err
+		}
+		tracking, err := r.bool(len(r.Params) - 1)
+		if err != nil {
+			return err
+		}
+		c.Tracking = tracking
+	case "P62":
+		// (8130630001,P62,1,10), command ID 2 carries the current mileage and is not a setting
+		if err := r.expect("P62", 2); err != nil {
+			return err
+		}
+		id, err := r.uint(0, 8)
+		if err != nil {
+			return err
+		}
+		if id != 1 {
+			return nil
+		}
+		threshold, err := r.uint(1, 32)
+		if err != nil {
+			return err
+		}
+		c.MileageSpeedThreshold = uint32(threshold)
+	case "P63":
+		// (8130630001,P63,1)
+		if err := r.expect("P63", 1); err != nil {
+			return err
+		}
+		enabled, err := r.bool(0)
+		if err != nil {
+			return err
+		}
+		c.GPSDriftOptimization = enabled
+	default:
+		return fmt.Errorf("unexpected command word %s for device config", r.Word)
+	}
+	return nil
+}
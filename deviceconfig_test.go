@@ -0,0 +1,69 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceConfig(t *testing.T) {
+	var rs []Response
+	for _, s := range []string{
+		"(8130630001,P03,1,5)",
+		"(8130630001,P04,60,30)",
+		"(8130630001,P37,126,15)",
+		"(8130630001,P39,1,10)",
+		"(8130630001,P54,1,0)",
+		"(8130630001,P62,1,10)",
+		"(8130630001,P62,2,999999)",
+		"(8130630001,P63,0)",
+	} {
+		r, err := ParseResponse([]byte(s))
+		assert.NoError(t, err)
+		rs = append(rs, r)
+	}
+
+	c, err := ParseDeviceConfig(rs...)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultDeviceConfig(), c)
+
+	r, _ := ParseResponse([]byte("(8130630001,P54,1,2)"))
+	_, err = ParseDeviceConfig(r)
+	assert.Error(t, err)
+	r, _ = ParseResponse([]byte("(8130630001,P01,JT701D)"))
+	_, err = ParseDeviceConfig(r)
+	assert.Error(t, err)
+}
+
+func TestDeviceConfigDiff(t *testing.T) {
+	current := DefaultDeviceConfig()
+	desired := current
+	desired.UploadInterval = 120
+	desired.Tracking = true
+
+	cmds, err := Diff(current, desired)
+	assert.NoError(t, err)
+	assert.Equal(t, []Command{NewCommand("P04", "1", "120", "30"), NewCommand("P54", "1", "1")}, cmds)
+
+	cmds, err = Diff(desired, desired)
+	assert.NoError(t, err)
+	assert.Empty(t, cmds)
+
+	desired.GSensorThreshold = 20
+	_, err = Diff(current, desired)
+	assert.Error(t, err)
+}
+
+func TestDeviceConfigCommands(t *testing.T) {
+	c := DefaultDeviceConfig()
+	cmds, err := c.Commands()
+	assert.NoError(t, err)
+	assert.Len(t, cmds, 7)
+	assert.Equal(t, "(P03,1,1,5)", cmds[0].String())
+	assert.Equal(t, "(P62,1,1,10)", cmds[5].String())
+	assert.Len(t, QueryDeviceConfig(), 7)
+
+	c.WakeWorkTime = 11
+	_, err = c.Commands()
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package jointechparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DeviceInfo is an asset register record merged from P01, P14 and P68 responses of a single device
+type DeviceInfo struct {
+	TerminalID      string
+	Firmware        string // Full firmware string, e.g. JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7
+	Model           string // Device model, e.g. JT701D
+	FirmwareVersion string // Firmware release date, e.g. 20210311
+	Module          string // Cellular module model name, e.g. SIM7600X
+	LoRa            bool   // Hardware has built-in LoRa gateway
+	Hardware        string // Hardware version, e.g. PCBV2.3_R1.2.7
+	BatteryLevel    uint8  // Battery level in percent reported with firmware version
+	IMEI            string // IMEI of GSM module (P14)
+	IMSI            string // SIM card IMSI (P68,1)
+	ICCID           string // SIM card ICCID (P68,2)
+}
+
+// QueryDeviceInfo returns P01, P14 and P68 commands querying device inventory data
+func QueryDeviceInfo() []Command {
+	return []Command{
+		NewCommand("P01"),
+		NewCommand("P14"),
+		NewCommand("P68", "1", "0"),
+		NewCommand("P68", "2", "0"),
+	}
+}
+
+// MergeDeviceInfo merges inventory responses of many devices into records keyed by TerminalID
+func MergeDeviceInfo(rs ...Response) (map[string]*DeviceInfo, error) {
+	infos := make(map[string]*DeviceInfo)
+	for _, r := range rs {
+		info, ok := infos[r.TerminalID]
+		if !ok {
+			info = &DeviceInfo{TerminalID: r.TerminalID}
+			infos[r.TerminalID] = info
+		}
+		if err := info.Apply(r); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// Apply updates record with P01, P14 or P68 response
+func (d *DeviceInfo) Apply(r Response) error {
+	if d.TerminalID != "" && d.TerminalID != r.TerminalID {
+		return fmt.Errorf("%s response is for terminal %s, want %s", r.Word, r.TerminalID, d.TerminalID)
+	}
+	d.TerminalID = r.TerminalID
+
+	switch r.Word {
+	case "P01":
+		// (8130630001,P01,JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7,41%)
+		if err := r.expect("P01", 2); err != nil {
+			return err
+		}
+		battery, err := strconv.ParseUint(strings.TrimSuffix(r.Params[1], "%"), 10, 8)
+		if err != nil {
+			return fmt.Errorf("P01 battery level, %v", err)
+		}
+		d.BatteryLevel = uint8(battery)
+		d.setFirmware(r.Params[0])
+	case "P14":
+		// (8130630001,P14,869999040159249)
+		if err := r.expect("P14", 1); err != nil {
+			return err
+		}
+		if !isDigits(r.Params[0], 15) {
+			return fmt.Errorf("invalid IMEI %q, want 15 digits", r.Params[0])
+		}
+		d.IMEI = r.Params[0]
+	case "P68":
+		// (8130630001,P68,1,460046236100038) or (8130630001,P68,2,89860442191970250038)
+		if err := r.expect("P68", 2); err != nil {
+			return err
+		}
+		switch r.Params[0] {
+		case "1":
+			d.IMSI = r.Params[1]
+		case "2":
+			d.ICCID = r.Params[1]
+		default:
+			return fmt.Errorf("invalid P68 query type %q, want 1 or 2", r.Params[0])
+		}
+	default:
+		return fmt.Errorf("unexpected command word %s for device info", r.Word)
+	}
+	return nil
+}
+
+// setFirmware splits firmware string into model, version, module, LoRa flag and hardware version
+func (d *DeviceInfo) setFirmware(fw string) {
+	d.Firmware = fw
+	d.Model, d.FirmwareVersion, d.Module, d.Hardware, d.LoRa = "", "", "", "", false
+
+	parts := strings.Split(fw, "_")
+	d.Model = parts[0]
+	if len(parts) > 1 {
+		d.FirmwareVersion = parts[1]
+	}
+	// cellular module name is followed by LoRa or NoLora gateway flag
+	for n := 2; n < len(parts); n++ {
+		switch {
+		case parts[n] == "LoRa" || parts[n] == "NoLora":
+			d.LoRa = parts[n] == "LoRa"
+			d.Module = parts[n-1]
+		case strings.HasPrefix(parts[n], "PCB"):
+			d.Hardware = strings.Join(parts[n:], "_")
+			return
+		}
+	}
+}
+
+// CheckIMEI returns an error when the IMEI reported by P14 differs from the one carried in position data
+func (d *DeviceInfo) CheckIMEI(decoded Decoded) error {
+	if d.IMEI == "" {
+		return fmt.Errorf("terminal %s has no P14 IMEI", d.TerminalID)
+	}
+	if decoded.TerminalID != d.TerminalID {
+		return fmt.Errorf("decoded data is for terminal %s, want %s", decoded.TerminalID, d.TerminalID)
+	}
+	if decoded.IMEI != d.IMEI {
+		return fmt.Errorf("terminal %s IMEI mismatch, P14 reports %s, position data carries %s", d.TerminalID, d.IMEI, decoded.IMEI)
+	}
+	return nil
+}
+
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
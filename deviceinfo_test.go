@@ -0,0 +1,65 @@
+package jointechparser
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeDeviceInfo(t *testing.T) {
+	var rs []Response
+	for _, s := range []string{
+		"(8130630001,P01,JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7,41%)",
+		"(8130630001,P14,869999040159249)",
+		"(8130630001,P68,1,460046236100038)",
+		"(8130630001,P68,2,89860442191970250038)",
+		"(8000620011,P01,JT701D_20211224_China_Jointech_EC200S_NoLora_PCBV2.1,100%)",
+	} {
+		r, err := ParseResponse([]byte(s))
+		assert.NoError(t, err)
+		rs = append(rs, r)
+	}
+
+	infos, err := MergeDeviceInfo(rs...)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, &DeviceInfo{
+		TerminalID:      "8130630001",
+		Firmware:        "JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7",
+		Model:           "JT701D",
+		FirmwareVersion: "20210311",
+		Module:          "SIM7600X",
+		LoRa:            true,
+		Hardware:        "PCBV2.3_R1.2.7",
+		BatteryLevel:    41,
+		IMEI:            "869999040159249",
+		IMSI:            "460046236100038",
+		ICCID:           "89860442191970250038",
+	}, infos["8130630001"])
+	assert.Equal(t, "EC200S", infos["8000620011"].Module)
+	assert.False(t, infos["8000620011"].LoRa)
+	assert.Equal(t, uint8(100), infos["8000620011"].BatteryLevel)
+
+	bad, _ := ParseResponse([]byte("(8130630001,P14,86999904015924)"))
+	_, err = MergeDeviceInfo(bad)
+	assert.Error(t, err)
+	assert.Len(t, QueryDeviceInfo(), 4)
+}
+
+func TestCheckIMEI(t *testing.T) {
+	hd1, _ := hex.DecodeString("2480006200111911003418042116225922348310113550543F12980000002D060000000020E028109228661F05010001")
+	hd3, _ := hex.DecodeString("000001CC0156")
+	bs := append(append(hd1, []byte("868822040248195")...), hd3...)
+	decoded, err := Decode(&bs)
+	assert.NoError(t, err)
+
+	info := DeviceInfo{TerminalID: "8000620011"}
+	assert.Error(t, info.CheckIMEI(decoded))
+	info.IMEI = "868822040248195"
+	assert.NoError(t, info.CheckIMEI(decoded))
+	info.IMEI = "869999040159249"
+	assert.Error(t, info.CheckIMEI(decoded))
+	info.TerminalID = "8130630001"
+	assert.Error(t, info.CheckIMEI(decoded))
+}
@@ -0,0 +1,53 @@
+package jointechparser
+
+import (
+	"fmt"
+	"time"
+)
+
+// FlashCacheSample is the number of records cached in device FLASH reported by P98,10 at given time
+type FlashCacheSample struct {
+	Count uint32
+	At    time.Time
+}
+
+// QueryFlashCache returns P98,10,0 command querying the number of records cached in device FLASH
+func QueryFlashCache() Command {
+	return NewCommand("P98", "10", "0")
+}
+
+// DeleteFlashCache returns P98,10,1,0,0 command deleting all records cached in device FLASH.
+// The device deletes for at most 15 seconds, when the reply is not 0 the command has to be sent again
+func DeleteFlashCache() Command {
+	return NewCommand("P98", "10", "1", "0", "0")
+}
+
+// ParseFlashCache parses P98,10 response of both query and delete command and returns the number of cached records
+func ParseFlashCache(r Response) (uint32, error) {
+	// (8130630001,P98,10,0,37,0)
+	if err := r.expect("P98", 3); err != nil {
+		return 0, err
+	}
+	if r.Params[0] != "10" {
+		return 0, fmt.Errorf("unexpected P98 instruction %s, want 10", r.Params[0])
+	}
+	count, err := r.uint(2, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(count), nil
+}
+
+// EstimateBacklogDrain returns how long uploading cached records takes when the device sends
+// one record per configured upload interval
+func EstimateBacklogDrain(count uint32, cfg DeviceConfig) time.Duration {
+	return time.Duration(count) * time.Duration(cfg.UploadInterval) * time.Second
+}
+
+// BacklogStuck reports whether the cache did not shrink between two samples taken more than one upload interval apart
+func BacklogStuck(earlier, later FlashCacheSample, cfg DeviceConfig) bool {
+	if earlier.Count == 0 || later.At.Sub(earlier.At) <= time.Duration(cfg.UploadInterval)*time.Second {
+		return false
+	}
+	return later.Count >= earlier.Count
+}
@@ -0,0 +1,36 @@
+package jointechparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlashCacheCommands(t *testing.T) {
+	assert.Equal(t, "(P98,10,0)", QueryFlashCache().String())
+	assert.Equal(t, "(P98,10,1,0,0)", DeleteFlashCache().String())
+}
+
+func TestParseFlashCache(t *testing.T) {
+	r, _ := ParseResponse([]byte("(8130630001,P98,10,0,37,0)"))
+	count, err := ParseFlashCache(r)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(37), count)
+
+	r, _ = ParseResponse([]byte("(8130630001,P98,6,6)"))
+	_, err = ParseFlashCache(r)
+	assert.Error(t, err)
+}
+
+func TestBacklogDrain(t *testing.T) {
+	cfg := DefaultDeviceConfig()
+	assert.Equal(t, 37*time.Minute, EstimateBacklogDrain(37, cfg))
+
+	now := time.Date(2021, 4, 18, 16, 22, 59, 0, time.UTC)
+	earlier := FlashCacheSample{Count: 37, At: now}
+	assert.False(t, BacklogStuck(earlier, FlashCacheSample{Count: 37, At: now.Add(30 * time.Second)}, cfg))
+	assert.True(t, BacklogStuck(earlier, FlashCacheSample{Count: 37, At: now.Add(5 * time.Minute)}, cfg))
+	assert.False(t, BacklogStuck(earlier, FlashCacheSample{Count: 30, At: now.Add(5 * time.Minute)}, cfg))
+	assert.False(t, BacklogStuck(FlashCacheSample{At: now}, FlashCacheSample{At: now.Add(time.Hour)}, cfg))
+}
@@ -0,0 +1,576 @@
+// Package ftpserver implements a minimal read-only FTP server serving firmware files to devices
+// upgraded by OTA-9 command. Only passive mode binary downloads are supported, which is what the
+// cellular modules of JT701D use.
+package ftpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdleTimeout = 5 * time.Minute
+	dataConnTimeout    = 30 * time.Second
+	chunkSize          = 32 * 1024
+)
+
+// ErrServerClosed is returned by Serve after Close was called
+var ErrServerClosed = errors.New("ftpserver: server closed")
+
+// TransferKey identifies downloads of a single device. Devices behind carrier NAT share source addresses,
+// they are told apart by FTP username, see Server.Users
+type TransferKey struct {
+	User string // FTP login username
+	File string // Downloaded file path
+}
+
+// Transfer is download progress of a single firmware file by a single device
+type Transfer struct {
+	RemoteIP string // Source address of the device
+	User     string // FTP login username
+	File     string // Downloaded file path
+	Size     int64  // Total bytes of the file
+	Sent     int64  // Bytes sent including resume offset
+	Done     bool   // Transfer finished, successfully when Err is nil
+	Err      error
+	Started  time.Time
+	Updated  time.Time
+}
+
+// Server is a read-only FTP server, zero Server is not usable, use New
+type Server struct {
+	FS          fs.FS             // Served files
+	User        string            // Login username, empty allows any username
+	Password    string            // Login password, checked only when User is set
+	Users       map[string]string // Login usernames with their passwords, e.g. one per device, replaces User when set
+	PassiveHost string            // IPv4 address announced in PASV reply, defaults to the local address of control connection
+	IdleTimeout time.Duration     // Control connection idle timeout, default 5 minutes
+	OnProgress  func(Transfer)
+	Logger      *log.Logger // Logs download progress, defaults to the standard logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	transfers map[TransferKey]Transfer
+	closed    bool
+}
+
+// New returns server serving files of directory dir
+func New(dir string) *Server {
+	return &Server{FS: os.DirFS(dir)}
+}
+
+// ListenAndServe listens on TCP address addr and serves FTP connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts FTP connections on l until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops listeners and closes all connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+// Transfers returns the latest transfer of every file by every FTP user
+func (s *Server) Transfers() map[TransferKey]Transfer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[TransferKey]Transfer, len(s.transfers))
+	for k, t := range s.transfers {
+		out[k] = t
+	}
+	return out
+}
+
+// authenticate checks login credentials against Users or User and Password
+func (s *Server) authenticate(user, password string) bool {
+	switch {
+	case s.Users != nil:
+		want, ok := s.Users[user]
+		return ok && password == want
+	case s.User != "":
+		return user == s.User && password == s.Password
+	}
+	return true
+}
+
+func (s *Server) track(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// report stores transfer progress and passes it to OnProgress
+func (s *Server) report(t Transfer) {
+	t.Updated = time.Now()
+	s.mu.Lock()
+	if s.transfers == nil {
+		s.transfers = make(map[TransferKey]Transfer)
+	}
+	s.transfers[TransferKey{User: t.User, File: t.File}] = t
+	s.mu.Unlock()
+	if s.OnProgress != nil {
+		s.OnProgress(t)
+	}
+}
+
+// session is a state of a single control connection
+type session struct {
+	s        *Server
+	conn     net.Conn
+	r        *bufio.Reader
+	remoteIP string
+	user     string
+	loggedIn bool
+	dir      string
+	offset   int64
+	pasv     net.Listener
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	ss := &session{s: s, conn: conn, r: bufio.NewReader(conn), dir: "/"}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ss.remoteIP = addr.IP.String()
+	}
+	defer ss.closePassive()
+
+	idle := s.IdleTimeout
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	ss.reply(220, "Firmware FTP server ready")
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		line, err := ss.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg, _ := strings.Cut(line, " ")
+		if !ss.handle(strings.ToUpper(cmd), arg) {
+			return
+		}
+	}
+}
+
+func (ss *session) reply(code int, msg string) {
+	fmt.Fprintf(ss.conn, "%d %s\r\n", code, msg)
+}
+
+// handle executes single command and returns false when the connection has to be closed
+func (ss *session) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		ss.user, ss.loggedIn = arg, false
+		ss.reply(331, "Password required")
+		return true
+	case "PASS":
+		if ss.user == "" {
+			ss.reply(503, "Login with USER first")
+			return true
+		}
+		if !ss.s.authenticate(ss.user, arg) {
+			ss.s.logf("ftp %s login failed for user %q", ss.remoteIP, ss.user)
+			ss.reply(530, "Login incorrect")
+			return true
+		}
+		ss.loggedIn = true
+		ss.reply(230, "Login successful")
+		return true
+	case "QUIT":
+		ss.reply(221, "Goodbye")
+		return false
+	case "SYST":
+		ss.reply(215, "UNIX Type: L8")
+		return true
+	case "FEAT":
+		fmt.Fprint(ss.conn, "211-Features:\r\n SIZE\r\n REST STREAM\r\n EPSV\r\n UTF8\r\n211 End\r\n")
+		return true
+	case "NOOP":
+		ss.reply(200, "OK")
+		return true
+	case "OPTS":
+		ss.reply(200, "OK")
+		return true
+	}
+
+	if !ss.loggedIn {
+		ss.reply(530, "Please login with USER and PASS")
+		return true
+	}
+
+	switch cmd {
+	case "TYPE":
+		switch strings.ToUpper(arg) {
+		case "I", "L 8", "A", "A N":
+			ss.reply(200, "Type set to "+arg)
+		default:
+			ss.reply(504, "Type not supported")
+		}
+	case "MODE":
+		if strings.ToUpper(arg) != "S" {
+			ss.reply(504, "Only stream mode is supported")
+			return true
+		}
+		ss.reply(200, "Mode set to S")
+	case "STRU":
+		if strings.ToUpper(arg) != "F" {
+			ss.reply(504, "Only file structure is supported")
+			return true
+		}
+		ss.reply(200, "Structure set to F")
+	case "PWD", "XPWD":
+		ss.reply(257, strconv.Quote(ss.dir)+" is the current directory")
+	case "CWD", "XCWD":
+		ss.changeDir(arg)
+	case "CDUP", "XCUP":
+		ss.changeDir("..")
+	case "SIZE":
+		name, ok := ss.resolve(arg)
+		if !ok {
+			ss.reply(550, "Invalid path")
+			return true
+		}
+		info, err := fs.Stat(ss.s.FS, name)
+		if err != nil || info.IsDir() {
+			ss.reply(550, "File not found")
+			return true
+		}
+		ss.reply(213, strconv.FormatInt(info.Size(), 10))
+	case "PASV":
+		ss.passive(false)
+	case "EPSV":
+		ss.passive(true)
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			ss.reply(501, "Invalid restart offset")
+			return true
+		}
+		ss.offset = offset
+		ss.reply(350, "Restarting at "+arg)
+	case "RETR":
+		ss.retrieve(arg)
+	case "LIST", "NLST":
+		ss.list(cmd == "LIST", arg)
+	case "STOR", "STOU", "APPE", "DELE", "RMD", "XRMD", "MKD", "XMKD", "RNFR", "RNTO", "SITE":
+		ss.reply(550, "Permission denied, server is read-only")
+	default:
+		ss.reply(502, "Command not implemented")
+	}
+	return true
+}
+
+// resolve returns fs.FS name of FTP path, false when the path escapes served directory
+func (ss *session) resolve(p string) (string, bool) {
+	if !path.IsAbs(p) {
+		p = path.Join(ss.dir, p)
+	}
+	p = path.Clean(p)
+	if p == "/" {
+		return ".", true
+	}
+	name := strings.TrimPrefix(p, "/")
+	return name, fs.ValidPath(name)
+}
+
+func (ss *session) changeDir(p string) {
+	name, ok := ss.resolve(p)
+	if !ok {
+		ss.reply(550, "Invalid path")
+		return
+	}
+	info, err := fs.Stat(ss.s.FS, name)
+	if err != nil || !info.IsDir() {
+		ss.reply(550, "Directory not found")
+		return
+	}
+	if name == "." {
+		ss.dir = "/"
+	} else {
+		ss.dir = "/" + name
+	}
+	ss.reply(250, "Directory changed to "+ss.dir)
+}
+
+func (ss *session) closePassive() {
+	if ss.pasv != nil {
+		ss.pasv.Close()
+		ss.pasv = nil
+	}
+}
+
+// passive opens data listener and announces it by PASV or EPSV reply
+func (ss *session) passive(extended bool) {
+	ss.closePassive()
+	local := ss.conn.LocalAddr().(*net.TCPAddr)
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: local.IP})
+	if err != nil {
+		ss.reply(425, "Can not open data connection")
+		return
+	}
+	ss.pasv = l
+	port := l.Addr().(*net.TCPAddr).Port
+	if extended {
+		ss.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+
+	host := local.IP.To4()
+	if ss.s.PassiveHost != "" {
+		host = net.ParseIP(ss.s.PassiveHost).To4()
+	}
+	if host == nil {
+		ss.closePassive()
+		ss.reply(425, "PASV requires IPv4, use EPSV")
+		return
+	}
+	ss.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)", host[0], host[1], host[2], host[3], port>>8, port&0xFF))
+}
+
+// dataConn accepts the data connection opened by the device, it must come from the same address as control connection
+func (ss *session) dataConn() (net.Conn, error) {
+	if ss.pasv == nil {
+		return nil, fmt.Errorf("use PASV first")
+	}
+	defer ss.closePassive()
+	ss.pasv.(*net.TCPListener).SetDeadline(time.Now().Add(dataConnTimeout))
+	for {
+		c, err := ss.pasv.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok && addr.IP.String() == ss.remoteIP {
+			return c, nil
+		}
+		c.Close()
+	}
+}
+
+func (ss *session) retrieve(p string) {
+	offset := ss.offset
+	ss.offset = 0
+
+	name, ok := ss.resolve(p)
+	if !ok {
+		ss.reply(550, "Invalid path")
+		return
+	}
+	f, err := ss.s.FS.Open(name)
+	if err != nil {
+		ss.reply(550, "File not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		ss.reply(550, "File not found")
+		return
+	}
+	if offset > 0 {
+		seeker, ok := f.(io.Seeker)
+		if !ok || offset > info.Size() {
+			ss.reply(554, "Invalid restart offset")
+			return
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			ss.reply(554, "Invalid restart offset")
+			return
+		}
+	}
+
+	data, err := ss.dataConn()
+	if err != nil {
+		ss.reply(425, "Can not open data connection, "+err.Error())
+		return
+	}
+	ss.reply(150, fmt.Sprintf("Opening BINARY mode data connection for %s (%d bytes)", name, info.Size()))
+
+	t := Transfer{RemoteIP: ss.remoteIP, User: ss.user, File: name, Size: info.Size(), Sent: offset, Started: time.Now()}
+	ss.s.logf("ftp %s started download of %s at %d/%d bytes", t.RemoteIP, t.File, t.Sent, t.Size)
+	ss.s.report(t)
+
+	buf := make([]byte, chunkSize)
+	step := t.Size / 10
+	next := t.Sent + step
+	for {
+		n, rerr := f.Read(buf)
+		if n > 0 {
+			data.SetWriteDeadline(time.Now().Add(dataConnTimeout))
+			if _, err := data.Write(buf[:n]); err != nil {
+				t.Err = err
+				break
+			}
+			t.Sent += int64(n)
+			if step > 0 && t.Sent >= next && t.Sent < t.Size {
+				ss.s.logf("ftp %s downloaded %d%% of %s", t.RemoteIP, t.Sent*100/t.Size, t.File)
+				next = t.Sent + step
+			}
+			ss.s.report(t)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			t.Err = rerr
+			break
+		}
+	}
+	if err := data.Close(); err != nil && t.Err == nil {
+		t.Err = err
+	}
+
+	t.Done = true
+	ss.s.report(t)
+	if t.Err != nil {
+		ss.s.logf("ftp %s download of %s failed at %d/%d bytes, %v", t.RemoteIP, t.File, t.Sent, t.Size, t.Err)
+		ss.reply(426, "Transfer aborted")
+		return
+	}
+	ss.s.logf("ftp %s finished download of %s, %d bytes", t.RemoteIP, t.File, t.Sent)
+	ss.reply(226, "Transfer complete")
+}
+
+func (ss *session) list(long bool, p string) {
+	// ignore ls style options such as -la
+	if strings.HasPrefix(p, "-") {
+		p = ""
+	}
+	name, ok := ss.resolve(p)
+	if !ok {
+		ss.reply(550, "Invalid path")
+		return
+	}
+	info, err := fs.Stat(ss.s.FS, name)
+	if err != nil {
+		ss.reply(550, "File not found")
+		return
+	}
+	var infos []fs.FileInfo
+	if info.IsDir() {
+		entries, err := fs.ReadDir(ss.s.FS, name)
+		if err != nil {
+			ss.reply(550, "Can not read directory")
+			return
+		}
+		for _, e := range entries {
+			if i, err := e.Info(); err == nil {
+				infos = append(infos, i)
+			}
+		}
+	} else {
+		infos = append(infos, info)
+	}
+
+	data, err := ss.dataConn()
+	if err != nil {
+		ss.reply(425, "Can not open data connection, "+err.Error())
+		return
+	}
+	ss.reply(150, "Here comes the directory listing")
+	w := bufio.NewWriter(data)
+	for _, i := range infos {
+		if !long {
+			fmt.Fprintf(w, "%s\r\n", i.Name())
+			continue
+		}
+		mode := "-r--r--r--"
+		if i.IsDir() {
+			mode = "dr-xr-xr-x"
+		}
+		fmt.Fprintf(w, "%s 1 ftp ftp %12d %s %s\r\n", mode, i.Size(), i.ModTime().Format("Jan _2 15:04"), i.Name())
+	}
+	err = w.Flush()
+	if cerr := data.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		ss.reply(426, "Transfer aborted")
+		return
+	}
+	ss.reply(226, "Directory send OK")
+}
@@ -0,0 +1,261 @@
+package ftpserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDevice is a FTP client behaving like the cellular module of JT701D
+type fakeDevice struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *fakeDevice {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	d := &fakeDevice{t: t, conn: conn, r: bufio.NewReader(conn)}
+	d.expect(220)
+	return d
+}
+
+// cmd sends a command and returns reply code and message
+func (d *fakeDevice) cmd(format string, v ...any) (int, string) {
+	fmt.Fprintf(d.conn, format+"\r\n", v...)
+	return d.read()
+}
+
+func (d *fakeDevice) read() (int, string) {
+	d.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := d.r.ReadString('\n')
+		if !assert.NoError(d.t, err) {
+			d.t.FailNow()
+		}
+		line = strings.TrimRight(line, "\r\n")
+		// skip multi line replies
+		if len(line) < 4 || line[3] != ' ' {
+			continue
+		}
+		code, _ := strconv.Atoi(line[:3])
+		return code, line[4:]
+	}
+}
+
+func (d *fakeDevice) expect(code int) string {
+	got, msg := d.read()
+	assert.Equal(d.t, code, got, msg)
+	return msg
+}
+
+func (d *fakeDevice) login(user, pass string) {
+	code, _ := d.cmd("USER %s", user)
+	assert.Equal(d.t, 331, code)
+	code, msg := d.cmd("PASS %s", pass)
+	assert.Equal(d.t, 230, code, msg)
+}
+
+// download retrieves file over passive data connection
+func (d *fakeDevice) download(name string) ([]byte, int) {
+	code, msg := d.cmd("PASV")
+	if !assert.Equal(d.t, 227, code, msg) {
+		d.t.FailNow()
+	}
+	var h1, h2, h3, h4, p1, p2 int
+	_, err := fmt.Sscanf(msg[strings.IndexByte(msg, '('):], "(%d,%d,%d,%d,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2)
+	assert.NoError(d.t, err)
+	data, err := net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, p1<<8|p2))
+	if !assert.NoError(d.t, err) {
+		d.t.FailNow()
+	}
+	defer data.Close()
+
+	code, msg = d.cmd("RETR %s", name)
+	if code != 150 {
+		return nil, code
+	}
+	bs, err := io.ReadAll(data)
+	assert.NoError(d.t, err)
+	code, msg = d.read()
+	return bs, code
+}
+
+func startServer(t *testing.T) (*Server, string, []byte) {
+	dir := t.TempDir()
+	firmware := bytes.Repeat([]byte("JT701D firmware "), 10000)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "JT701_19.bin"), firmware, 0o644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "old"), 0o755))
+
+	s := New(dir)
+	s.User, s.Password = "test1", "Ab123456"
+	s.Logger = log.New(io.Discard, "", 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String(), firmware
+}
+
+func TestDownload(t *testing.T) {
+	s, addr, firmware := startServer(t)
+	var mu sync.Mutex
+	var progress []Transfer
+	s.OnProgress = func(tr Transfer) {
+		mu.Lock()
+		progress = append(progress, tr)
+		mu.Unlock()
+	}
+
+	d := dial(t, addr)
+	d.login("test1", "Ab123456")
+	code, _ := d.cmd("TYPE I")
+	assert.Equal(t, 200, code)
+	code, msg := d.cmd("SIZE JT701_19.bin")
+	assert.Equal(t, 213, code)
+	assert.Equal(t, strconv.Itoa(len(firmware)), msg)
+
+	bs, code := d.download("JT701_19.bin")
+	assert.Equal(t, 226, code)
+	assert.Equal(t, firmware, bs)
+
+	mu.Lock()
+	assert.Greater(t, len(progress), 2)
+	last := progress[len(progress)-1]
+	mu.Unlock()
+	assert.True(t, last.Done)
+	assert.NoError(t, last.Err)
+	assert.Equal(t, "127.0.0.1", last.RemoteIP)
+	assert.Equal(t, int64(len(firmware)), last.Sent)
+	assert.Equal(t, last, s.Transfers()[TransferKey{User: "test1", File: "JT701_19.bin"}])
+
+	// resume interrupted download
+	code, _ = d.cmd("REST 100000")
+	assert.Equal(t, 350, code)
+	bs, code = d.download("/JT701_19.bin")
+	assert.Equal(t, 226, code)
+	assert.Equal(t, firmware[100000:], bs)
+
+	code, _ = d.cmd("QUIT")
+	assert.Equal(t, 221, code)
+}
+
+func TestReadOnly(t *testing.T) {
+	_, addr, _ := startServer(t)
+	d := dial(t, addr)
+
+	code, _ := d.cmd("RETR JT701_19.bin")
+	assert.Equal(t, 530, code)
+	d.cmd("USER test1")
+	code, _ = d.cmd("PASS wrong")
+	assert.Equal(t, 530, code)
+	d.login("test1", "Ab123456")
+
+	for _, c := range []string{"STOR evil.bin", "DELE JT701_19.bin", "MKD new"} {
+		code, _ := d.cmd(c)
+		assert.Equal(t, 550, code, c)
+	}
+	code, _ = d.cmd("SIZE ../../etc/passwd")
+	assert.Equal(t, 550, code)
+	code, _ = d.cmd("CWD old")
+	assert.Equal(t, 250, code)
+	code, msg := d.cmd("PWD")
+	assert.Equal(t, 257, code)
+	assert.Equal(t, `"/old" is the current directory`, msg)
+	code, _ = d.cmd("SIZE JT701_19.bin")
+	assert.Equal(t, 550, code)
+	code, _ = d.cmd("CDUP")
+	assert.Equal(t, 250, code)
+	code, _ = d.cmd("SIZE JT701_19.bin")
+	assert.Equal(t, 213, code)
+	_, code = d.download("missing.bin")
+	assert.Equal(t, 550, code)
+}
+
+func TestJobTracker(t *testing.T) {
+	s, addr, firmware := startServer(t)
+	s.Users = map[string]string{"dev1": "Ab123456", "dev2": "Cd123456"}
+	size, checksum := jointechparser.FirmwareChecksum(firmware)
+	newJob := func(terminalID, user, password string) *jointechparser.OTAJob {
+		job, err := jointechparser.NewOTAJob(terminalID, jointechparser.OTARequest{
+			Host:     "127.0.0.1",
+			Port:     21,
+			User:     user,
+			Password: password,
+			File:     "JT701_19.bin",
+			Size:     size,
+			Checksum: checksum,
+		}, "20220105")
+		assert.NoError(t, err)
+		return job
+	}
+
+	// both devices connect from the same address behind carrier NAT
+	tracker := &JobTracker{}
+	assert.NoError(t, tracker.Track(newJob("8130630001", "dev1", "Ab123456")))
+	assert.NoError(t, tracker.Track(newJob("8130630002", "dev2", "Cd123456")))
+	s.OnProgress = tracker.Progress
+
+	d := dial(t, addr)
+	code, _ := d.cmd("USER test1")
+	assert.Equal(t, 331, code)
+	code, _ = d.cmd("PASS Ab123456")
+	assert.Equal(t, 530, code)
+	d.login("dev1", "Ab123456")
+	_, code = d.download("JT701_19.bin")
+	assert.Equal(t, 226, code)
+
+	got, ok := tracker.Job("8130630001")
+	assert.True(t, ok)
+	assert.Equal(t, jointechparser.OTADownloading, got.State)
+	assert.Equal(t, size, got.Downloaded)
+	got, _ = tracker.Job("8130630002")
+	assert.Equal(t, jointechparser.OTARequested, got.State)
+
+	assert.True(t, tracker.Update("8130630001", func(job *jointechparser.OTAJob) {
+		job.ApplyReply(jointechparser.OTAReply{TerminalID: "8130630001", Status: jointechparser.OTAInstalling})
+	}))
+	got, _ = tracker.Job("8130630001")
+	assert.Equal(t, jointechparser.OTAVerifying, got.State)
+	assert.False(t, tracker.Update("8000620011", func(*jointechparser.OTAJob) {}))
+
+	// unfinished jobs can not share username, the device of a finished one can be upgraded again
+	assert.ErrorContains(t, tracker.Track(newJob("8130630003", "dev2", "Cd123456")), "terminal 8130630002")
+	_, ok = tracker.Job("8130630003")
+	assert.False(t, ok)
+	tracker.Update("8130630002", func(job *jointechparser.OTAJob) { job.Cancel() })
+	assert.NoError(t, tracker.Track(newJob("8130630003", "dev2", "Cd123456")))
+	assert.NoError(t, tracker.Track(newJob("8130630003", "dev2", "Cd123456")))
+	tracker.Progress(Transfer{User: "dev2", File: "JT701_19.bin", Sent: 4096})
+	got, _ = tracker.Job("8130630003")
+	assert.Equal(t, uint32(4096), got.Downloaded)
+}
+
+func TestClose(t *testing.T) {
+	s, addr, _ := startServer(t)
+	d := dial(t, addr)
+	assert.NoError(t, s.Close())
+	d.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := d.r.ReadByte()
+	assert.Error(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
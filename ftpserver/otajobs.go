@@ -0,0 +1,76 @@
+package ftpserver
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// JobTracker passes download progress to OTA jobs of devices, jobs are keyed by TerminalID and matched to transfers
+// by FTP username and firmware file of their OTARequest. Source addresses are not used as devices behind carrier NAT
+// share them, so unfinished jobs must not share both, give each device its own username, see Server.Users.
+// Jobs are owned by the tracker, use Update to change them from other goroutines
+type JobTracker struct {
+	mu   sync.Mutex
+	jobs map[string]*jointechparser.OTAJob
+}
+
+// Track registers job of a device, it replaces previous job of the same terminal. It fails when unfinished job
+// of another terminal downloads the same file with the same username, their progress could not be told apart
+func (t *JobTracker) Track(job *jointechparser.OTAJob) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, other := range t.jobs {
+		if id != job.TerminalID && !other.Done() && sameDownload(other, job.Request.User, job.Request.File) {
+			return fmt.Errorf("terminal %s downloads %s as FTP user %s too, use unique username", id, job.Request.File, job.Request.User)
+		}
+	}
+	if t.jobs == nil {
+		t.jobs = make(map[string]*jointechparser.OTAJob)
+	}
+	t.jobs[job.TerminalID] = job
+	return nil
+}
+
+// Progress updates unfinished job matching transfer username and firmware file, it is meant to be used as
+// Server.OnProgress
+func (t *JobTracker) Progress(tr Transfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, job := range t.jobs {
+		if !job.Done() && sameDownload(job, tr.User, tr.File) {
+			job.Progress(uint32(tr.Sent))
+			return
+		}
+	}
+}
+
+// sameDownload reports whether job downloads file as FTP user
+func sameDownload(job *jointechparser.OTAJob, user, file string) bool {
+	return job.Request.User == user && path.Base(job.Request.File) == path.Base(file)
+}
+
+// Update calls fn with job of terminal under the tracker lock, e.g. to apply OTA-9 or P01 responses.
+// It returns false when there is no such job
+func (t *JobTracker) Update(terminalID string, fn func(job *jointechparser.OTAJob)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[terminalID]
+	if ok {
+		fn(job)
+	}
+	return ok
+}
+
+// Job returns a copy of job of terminal
+func (t *JobTracker) Job(terminalID string) (jointechparser.OTAJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[terminalID]
+	if !ok {
+		return jointechparser.OTAJob{}, false
+	}
+	return *job, true
+}
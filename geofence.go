@@ -0,0 +1,275 @@
+package jointechparser
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxGeofences         = 10 // Number of fences supported by the device
+	MaxGeofenceNodes     = 50 // Number of location nodes supported by a single fence
+	geofenceNodesPerPage = 10 // Max nodes carried by a single P29 command
+	maxGeofenceNameLen   = 16
+	// circleGeofenceNodes is the number of polygon nodes a circle is approximated with
+	circleGeofenceNodes = 20
+	earthRadiusM        = 6371000.0
+)
+
+// GeofenceNode is a single fence location node in decimal degrees, negative values mean south latitude or west longitude
+type GeofenceNode struct {
+	Lat float64
+	Lng float64
+}
+
+// GeofenceCircle describes a circular fence, the device only stores polygons so it is sent as a polygon approximation
+type GeofenceCircle struct {
+	Center GeofenceNode
+	Radius float64 // Radius in meters
+}
+
+// Geofence represents one of the 10 device fences reported in PALData.FenceAlarmID
+type Geofence struct {
+	ID      uint8           // Fence ID in range [1~10]
+	Name    string          // Letters and numbers, max 16 characters
+	Enabled bool            // Enter and exit alarms are generated only for enabled fences, P40 switches them per alarm type
+	Nodes   []GeofenceNode  // Polygon nodes, ignored when Circle is set
+	Circle  *GeofenceCircle // Circle definition, replaces Nodes when sending to the device
+
+	received []bool // Nodes received by P29 pages, nil once all pages are received
+}
+
+// Polygon returns fence nodes as they will be stored in the device
+func (g *Geofence) Polygon() []GeofenceNode {
+	if g.Circle == nil {
+		return g.Nodes
+	}
+
+	nodes := make([]GeofenceNode, 0, circleGeofenceNodes)
+	lat := g.Circle.Center.Lat * math.Pi / 180
+	lng := g.Circle.Center.Lng * math.Pi / 180
+	d := g.Circle.Radius / earthRadiusM
+	for n := 0; n < circleGeofenceNodes; n++ {
+		bearing := 2 * math.Pi * float64(n) / circleGeofenceNodes
+		nLat := math.Asin(math.Sin(lat)*math.Cos(d) + math.Cos(lat)*math.Sin(d)*math.Cos(bearing))
+		nLng := lng + math.Atan2(math.Sin(bearing)*math.Sin(d)*math.Cos(lat), math.Cos(d)-math.Sin(lat)*math.Sin(nLat))
+		nodes = append(nodes, GeofenceNode{Lat: nLat * 180 / math.Pi, Lng: nLng * 180 / math.Pi})
+	}
+	return nodes
+}
+
+// Validate checks fence values against the limits given by the JT701D protocol manual
+func (g *Geofence) Validate() error {
+	if pages := g.MissingPages(); len(pages) > 0 {
+		return fmt.Errorf("geofence %d misses P29 pages %v", g.ID, pages)
+	}
+	if g.ID < 1 || g.ID > MaxGeofences {
+		return fmt.Errorf("invalid geofence ID, want 1-%d, got %d", MaxGeofences, g.ID)
+	}
+	if len(g.Name) == 0 || len(g.Name) > maxGeofenceNameLen {
+		return fmt.Errorf("invalid geofence name %q, want 1-%d characters", g.Name, maxGeofenceNameLen)
+	}
+	for _, r := range g.Name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return fmt.Errorf("invalid geofence name %q, only letters and numbers are allowed", g.Name)
+		}
+	}
+	if g.Circle != nil && g.Circle.Radius <= 0 {
+		return fmt.Errorf("invalid geofence radius, want > 0, got %v", g.Circle.Radius)
+	}
+
+	nodes := g.Polygon()
+	if len(nodes) < 3 || len(nodes) > MaxGeofenceNodes {
+		return fmt.Errorf("invalid number of geofence nodes, want 3-%d, got %d", MaxGeofenceNodes, len(nodes))
+	}
+	for _, n := range nodes {
+		if n.Lat < -85 || n.Lat > 85 || n.Lng < -180 || n.Lng > 180 {
+			return fmt.Errorf("invalid geofence node %v", n)
+		}
+	}
+	return nil
+}
+
+// Commands returns the command sequence configuring the fence: P24 name and state, P30 deletes old nodes,
+// P29 sends nodes split to pages of 10 and P31 notifies the device that configuration is done
+func (g *Geofence) Commands() ([]Command, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	id := strconv.Itoa(int(g.ID))
+	cmds := []Command{
+		NewCommand("P24", "1", id, formatBool(g.Enabled), g.Name),
+		NewCommand("P30", id),
+	}
+
+	nodes := g.Polygon()
+	for page := 0; page*geofenceNodesPerPage < len(nodes); page++ {
+		pageNodes := nodes[page*geofenceNodesPerPage : min(len(nodes), (page+1)*geofenceNodesPerPage)]
+		params := []string{"1", id, strconv.Itoa(page + 1), strconv.Itoa(len(pageNodes))}
+		for _, n := range pageNodes {
+			params = append(params, formatDegreesMinutes(n.Lng), formatDegreesMinutes(n.Lat))
+		}
+		cmds = append(cmds, NewCommand("P29", params...))
+	}
+
+	return append(cmds, NewCommand("P31")), nil
+}
+
+// QueryGeofence returns commands querying fence name, state and nodes
+func QueryGeofence(id uint8) []Command {
+	return []Command{
+		NewCommand("P24", "0", strconv.Itoa(int(id))),
+		NewCommand("P29", "0", strconv.Itoa(int(id))),
+	}
+}
+
+// ParseGeofence builds Geofence from P24 and P29 query responses of a single fence
+func ParseGeofence(rs ...Response) (Geofence, error) {
+	g := Geofence{}
+	for _, r := range rs {
+		if err := g.Apply(r); err != nil {
+			return Geofence{}, err
+		}
+	}
+	if pages := g.MissingPages(); len(pages) > 0 {
+		return Geofence{}, fmt.Errorf("geofence %d misses P29 pages %v", g.ID, pages)
+	}
+	return g, nil
+}
+
+// MissingPages returns numbers of P29 pages not applied yet, nodes of missing pages are zero
+func (g *Geofence) MissingPages() []int {
+	var pages []int
+	for n := 0; n < len(g.received); n += geofenceNodesPerPage {
+		if !g.received[n] {
+			pages = append(pages, n/geofenceNodesPerPage+1)
+		}
+	}
+	return pages
+}
+
+// Apply updates fence with P24 or P29 response, P29 pages may come in any order
+func (g *Geofence) Apply(r Response) error {
+	switch r.Word {
+	case "P24":
+		// (8130630001,P24,10,1,area10)
+		if err := r.expect("P24", 3); err != nil {
+			return err
+		}
+		if err := g.applyID(r, 0); err != nil {
+			return err
+		}
+		enabled, err := r.bool(1)
+		if err != nil {
+			return err
+		}
+		g.Enabled = enabled
+		g.Name = r.Params[2]
+	case "P29":
+		// (8130630001,P29,1,9,1,9,11400.6230,2233.6325,...)
+		if err := r.expect("P29", 4); err != nil {
+			return err
+		}
+		if err := g.applyID(r, 0); err != nil {
+			return err
+		}
+		total, err := r.uint(1, 8)
+		if err != nil {
+			return err
+		}
+		page, err := r.uint(2, 8)
+		if err != nil {
+			return err
+		}
+		count, err := r.uint(3, 8)
+		if err != nil {
+			return err
+		}
+		if total > MaxGeofenceNodes || page < 1 || count > geofenceNodesPerPage || int(page-1)*geofenceNodesPerPage+int(count) > int(total) {
+			return fmt.Errorf("invalid P29 page %d with %d of %d nodes", page, count, total)
+		}
+		if len(r.Params) != 4+2*int(count) {
+			return fmt.Errorf("P29 response has %d coordinates, want %d", len(r.Params)-4, 2*count)
+		}
+
+		if len(g.Nodes) != int(total) {
+			nodes := make([]GeofenceNode, total)
+			copy(nodes, g.Nodes)
+			g.Nodes = nodes
+			received := make([]bool, total)
+			copy(received, g.received)
+			g.received = received
+		}
+		offset := int(page-1) * geofenceNodesPerPage
+		for n := 0; n < int(count); n++ {
+			lng, err := parseDegreesMinutes(r.Params[4+2*n])
+			if err != nil {
+				return fmt.Errorf("P29 node %d longitude, %v", offset+n+1, err)
+			}
+			lat, err := parseDegreesMinutes(r.Params[5+2*n])
+			if err != nil {
+				return fmt.Errorf("P29 node %d latitude, %v", offset+n+1, err)
+			}
+			g.Nodes[offset+n] = GeofenceNode{Lat: lat, Lng: lng}
+		}
+		if g.received != nil {
+			for n := 0; n < int(count); n++ {
+				g.received[offset+n] = true
+			}
+			if !slices.Contains(g.received, false) {
+				g.received = nil
+			}
+		}
+		g.Circle = nil
+	default:
+		return fmt.Errorf("unexpected command word %s, want P24 or P29", r.Word)
+	}
+	return nil
+}
+
+func (g *Geofence) applyID(r Response, n int) error {
+	id, err := r.uint(n, 8)
+	if err != nil {
+		return err
+	}
+	if g.ID != 0 && g.ID != uint8(id) {
+		return fmt.Errorf("%s response is for geofence %d, want %d", r.Word, id, g.ID)
+	}
+	g.ID = uint8(id)
+	return nil
+}
+
+// formatDegreesMinutes converts decimal degrees to signed DDDMM.MMMM format used by fence nodes
+func formatDegreesMinutes(deg float64) string {
+	sign := ""
+	if deg < 0 {
+		sign = "-"
+		deg = -deg
+	}
+	// work in 1/10000 of a minute so rounding can not produce 60 minutes
+	ticks := int64(math.Round(deg * 600000))
+	d, m := ticks/600000, ticks%600000
+	return fmt.Sprintf("%s%d%02d.%04d", sign, d, m/10000, m%10000)
+}
+
+// parseDegreesMinutes converts signed DDDMM.MMMM value to decimal degrees
+func parseDegreesMinutes(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	sign := 1.0
+	if v < 0 {
+		sign = -1
+		v = -v
+	}
+	d := math.Floor(v / 100)
+	m := v - d*100
+	if m >= 60 {
+		return 0, fmt.Errorf("invalid minutes in %s", s)
+	}
+	return sign * (d + m/60), nil
+}
@@ -0,0 +1,94 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeofenceCommands(t *testing.T) {
+	g := Geofence{ID: 3, Name: "area3", Enabled: true}
+	for n := 0; n < 12; n++ {
+		g.Nodes = append(g.Nodes, GeofenceNode{Lat: 22.5 + float64(n)/100, Lng: -75.5})
+	}
+
+	cmds, err := g.Commands()
+	assert.NoError(t, err)
+	assert.Len(t, cmds, 5)
+	assert.Equal(t, "(P24,1,3,1,area3)", cmds[0].String())
+	assert.Equal(t, "(P30,3)", cmds[1].String())
+	assert.Equal(t, []string{"1", "3", "1", "10", "-7530.0000", "2230.0000"}, cmds[2].Params[:6])
+	assert.Len(t, cmds[2].Params, 4+20)
+	assert.Equal(t, "(P29,1,3,2,2,-7530.0000,2236.0000,-7530.0000,2236.6000)", cmds[3].String())
+	assert.Equal(t, "(P31)", cmds[4].String())
+}
+
+func TestGeofenceCircle(t *testing.T) {
+	g := Geofence{ID: 1, Name: "depot", Circle: &GeofenceCircle{Center: GeofenceNode{Lat: 22.58, Lng: 113.91}, Radius: 500}}
+	cmds, err := g.Commands()
+	assert.NoError(t, err)
+	// P24, P30, 2 pages of P29 and P31
+	assert.Len(t, cmds, 5)
+	for _, n := range g.Polygon() {
+		assert.InDelta(t, 22.58, n.Lat, 0.005)
+		assert.InDelta(t, 113.91, n.Lng, 0.006)
+	}
+}
+
+func TestGeofenceValidate(t *testing.T) {
+	nodes := []GeofenceNode{{1, 1}, {1, 2}, {2, 2}}
+	for _, g := range []Geofence{
+		{ID: 0, Name: "a", Nodes: nodes},
+		{ID: 11, Name: "a", Nodes: nodes},
+		{ID: 1, Name: "", Nodes: nodes},
+		{ID: 1, Name: "area,1", Nodes: nodes},
+		{ID: 1, Name: "a12345678901234567", Nodes: nodes},
+		{ID: 1, Name: "a", Nodes: nodes[:2]},
+		{ID: 1, Name: "a", Nodes: make([]GeofenceNode, 51)},
+		{ID: 1, Name: "a", Circle: &GeofenceCircle{}},
+	} {
+		_, err := g.Commands()
+		assert.Error(t, err, g)
+	}
+}
+
+func TestParseGeofence(t *testing.T) {
+	name, _ := ParseResponse([]byte("(8130630001,P24,1,1,area1)"))
+	page1, _ := ParseResponse([]byte("(8130630001,P29,1,12,1,10,11400.6230,2233.6325,11400.7988,2233.7466,11400.9575,2233.7686,11401.0304,2233.6775,11401.0434,2233.5696,11401.0221,2233.4972,11400.7991,2233.4543,11400.6833,2233.4570,11400.6618,2233.4688,11400.6000,2233.4000)"))
+	page2, _ := ParseResponse([]byte("(8130630001,P29,1,12,2,2,-7531.1858,-832.9230,-7529.5627,832.3909)"))
+
+	g, err := ParseGeofence(page2, name, page1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), g.ID)
+	assert.Equal(t, "area1", g.Name)
+	assert.True(t, g.Enabled)
+	assert.Len(t, g.Nodes, 12)
+	assert.InDelta(t, 114.010383, g.Nodes[0].Lng, 0.000001)
+	assert.InDelta(t, 22.560542, g.Nodes[0].Lat, 0.000001)
+	assert.InDelta(t, -75.519763, g.Nodes[10].Lng, 0.000001)
+	assert.InDelta(t, -8.548717, g.Nodes[10].Lat, 0.000001)
+
+	// round trip back to the same P29 parameters
+	cmds, err := g.Commands()
+	assert.NoError(t, err)
+	assert.Equal(t, page2.Params[4:], cmds[3].Params[4:])
+	assert.Equal(t, page1.Params[4:], cmds[2].Params[4:])
+
+	other, _ := ParseResponse([]byte("(8130630001,P24,2,1,area2)"))
+	_, err = ParseGeofence(name, other)
+	assert.Error(t, err)
+}
+
+func TestParseGeofenceMissingPage(t *testing.T) {
+	name, _ := ParseResponse([]byte("(8130630001,P24,1,1,area1)"))
+	page2, _ := ParseResponse([]byte("(8130630001,P29,1,12,2,2,-7531.1858,-832.9230,-7529.5627,832.3909)"))
+
+	_, err := ParseGeofence(name, page2)
+	assert.EqualError(t, err, "geofence 1 misses P29 pages [1]")
+
+	g := Geofence{}
+	assert.NoError(t, g.Apply(name))
+	assert.NoError(t, g.Apply(page2))
+	assert.Equal(t, []int{1}, g.MissingPages())
+	assert.EqualError(t, g.Validate(), "geofence 1 misses P29 pages [1]")
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/CliffJr/b2n"
@@ -18,6 +19,27 @@ const (
 	b1 = byte(0b11110000)
 )
 
+// PositionHeaderLen is the size of 0x24 packet header, from the protocol header to the Length field
+const PositionHeaderLen = 10
+
+// positionDataLen is the Length declared by 0x24 packet, from the date field to the data serial number
+const positionDataLen = 0x34
+
+// MinPositionFrameLen is the size of 0x24 packet carrying positionDataLen bytes of data, 62 bytes per manual
+const MinPositionFrameLen = PositionHeaderLen + positionDataLen
+
+// legacyIMEILen is the number of extra bytes of firmware sending IMEI as 15 ASCII digits instead of 8 BCD bytes,
+// the declared Length is not updated by such firmware
+const legacyIMEILen = 15 - 8
+
+// Data types of 0x24 packet
+const (
+	RealTimePosition  uint8 = 1
+	AlarmData         uint8 = 2
+	BlindAreaPosition uint8 = 3 // Position data cached in FLASH while the device was out of coverage
+	SubNewPosition    uint8 = 4 // Newly added by JT701D
+)
+
 // Decoded struct represent decoded E-Lock JointTech root data structure with
 // PAL (Positional / Alarm Lock) Data as return from Decode function
 type Decoded struct {
@@ -29,7 +51,12 @@ type Decoded struct {
 	DataType            uint8
 	BindVehicleID       string
 	ContainsHealthcheck bool
-	Data                []PALData // Slice containing P(ositional)A(larm)L(ocation) data
+	Data                []PALData        // Slice containing P(ositional)A(larm)L(ocation) data
+	Responses           []Response       // ASCII command responses found in the packet
+	OTAReplies          []OTAReply       // OTA-9 firmware upgrade responses found in the packet
+	Peripherals         []PeripheralData // WLNET,5 peripheral data found in the packet
+	LockReports         []LockReport     // P45 lock and unlock reports found in the packet
+	Unparsed            [][]byte         // WLNET,5 and OTA-9 packets failing to parse, as received
 }
 
 type HighByteLockEvent byte
@@ -99,6 +126,15 @@ func (p *PALData) LAC() uint16 {
 	return uint16(p.CellIdPositionCode & 0x0000FFFF)
 }
 
+// Timestamp returns UTC time of the record from Date and Time, Utime holds only the time of day
+func (p *PALData) Timestamp() (time.Time, error) {
+	t, err := time.Parse("020106150405", p.Date+p.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("record date %q and time %q, %v", p.Date, p.Time, err)
+	}
+	return t, nil
+}
+
 func (k HighByteLockEvent) String() string {
 	if k > LongTimeUnlocking {
 		return fmt.Sprintf("<unknown key: %d>", k)
@@ -182,6 +218,41 @@ func (p *PALData) HasLowEvent(key LowByteLockEvent) bool {
 }
 
 // Decode takes a pointer to a slice of bytes with raw data and return Decoded struct
+// PositionFrameLen returns the size of 0x24 packet starting with header, it is the header followed by the
+// declared Length bytes. The header is checked for BCD terminal ID, data type and minimal data length
+func PositionFrameLen(header []byte) (int, error) {
+	if len(header) < PositionHeaderLen || header[0] != 0x24 {
+		return 0, fmt.Errorf("incomplete position packet header % x", header)
+	}
+	for _, b := range header[1:6] {
+		if b>>4 > 9 || b&b2 > 9 {
+			return 0, fmt.Errorf("terminal ID %x is not BCD", header[1:6])
+		}
+	}
+	if dataType := header[7] & b2; dataType < RealTimePosition || dataType > SubNewPosition {
+		return 0, fmt.Errorf("unknown position data type %d", dataType)
+	}
+	n := int(header[8])<<8 | int(header[9])
+	if n < positionDataLen {
+		return 0, fmt.Errorf("position data length %d is shorter than %d", n, positionDataLen)
+	}
+	return PositionHeaderLen + n, nil
+}
+
+// hasLegacyIMEI reports whether the 0x24 packet of size n carries 15 ASCII digits of IMEI
+func hasLegacyIMEI(frame []byte, n int) bool {
+	const at = 48
+	if n != MinPositionFrameLen || len(frame) < n+legacyIMEILen {
+		return false
+	}
+	for _, b := range frame[at : at+15] {
+		if b < '0' || b > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func Decode(bs *[]byte) (Decoded, error) {
 	decoded := Decoded{}
 	decoded.Data = make([]PALData, 0, 20)
@@ -207,14 +278,35 @@ outerLoop:
 				i = i + 16
 				continue outerLoop
 			}
-			// TODO: implement parsing command output at this point
-			if len(*bs) > i+15 && (*bs)[i+13] != 0x4A && (*bs)[i+14] != 0x54 && (*bs)[i+15] != 0x29 {
-				for i < len(*bs) && (*bs)[i] != 0x29 {
-					i++
+			// command output, anything else in brackets is skipped
+			start := i
+			for i < len(*bs) && (*bs)[i] != 0x29 {
+				i++
+			}
+			if i < len(*bs) {
+				frame := (*bs)[start : i+1]
+				if isPeripheralData(frame) {
+					if p, err := ParsePeripheralData(frame); err == nil {
+						decoded.Peripherals = append(decoded.Peripherals, p)
+					} else {
+						decoded.Unparsed = append(decoded.Unparsed, frame)
+					}
+				} else if isOTAReply(frame) {
+					if r, err := ParseOTAReply(frame); err == nil {
+						decoded.OTAReplies = append(decoded.OTAReplies, r)
+					} else {
+						decoded.Unparsed = append(decoded.Unparsed, frame)
+					}
+				} else if r, err := ParseResponse(frame); err == nil {
+					if r.Word != "P45" {
+						decoded.Responses = append(decoded.Responses, r)
+					} else if lr, err := ParseLockReport(r); err == nil {
+						decoded.LockReports = append(decoded.LockReports, lr)
+					}
 				}
-				i = i + 1
-				continue outerLoop
 			}
+			i = i + 1
+			continue outerLoop
 
 		}
 
@@ -222,6 +314,19 @@ outerLoop:
 			break
 		}
 
+		start := i
+		frameLen, err := PositionFrameLen((*bs)[i:])
+		if err != nil {
+			return Decoded{}, fmt.Errorf("Decode error header, %v", err)
+		}
+		legacyIMEI := hasLegacyIMEI((*bs)[i:], frameLen)
+		if legacyIMEI {
+			frameLen += legacyIMEILen
+		}
+		if len(*bs) < start+frameLen {
+			return Decoded{}, fmt.Errorf("Decode error, position packet needs %d bytes, got %d", frameLen, len(*bs)-start)
+		}
+
 		//// determine protocol header in packet
 		decodedProtocolHeader, err := b2n.ParseBs2Uint8(bs, i)
 		if err != nil {
@@ -465,50 +570,54 @@ outerLoop:
 			return Decoded{}, fmt.Errorf("Decode error ExpandedDeviceStatus2, %v", err)
 		}
 
-		//48 868822040248195F 15 places it's not in hex it's in ascii format
-		p := (*bs)[i : i+15]
-		decoded.IMEI = *(*string)(unsafe.Pointer(&p))
-		//63
-		i = i + 15
-		if err != nil {
-			return Decoded{}, fmt.Errorf("Decode error IMEI, %v", err)
+		//48 868822040248195F in BCD, 0F0F0F0F0F0F0F0F when reserved, some firmware sends 15 ASCII digits instead
+		if legacyIMEI {
+			p := (*bs)[i : i+15]
+			decoded.IMEI = *(*string)(unsafe.Pointer(&p))
+			i = i + 15
+		} else {
+			imei := strings.TrimRight(hex.EncodeToString((*bs)[i:i+8]), "f")
+			if isDigits(imei, 15) {
+				decoded.IMEI = imei
+			}
+			i = i + 8
 		}
 
 		// Skip Cell ID in packet since it is part of CellIdPositionCode
-		//i=63
+		//i=56
 		_, err = b2n.ParseBs2Uint16(bs, i)
-		//65
+		//58
 		i = i + 2
 		if err != nil {
 			return Decoded{}, fmt.Errorf("Decode error CellId, %v", err)
 		}
 
 		// determine Mcc in packet
-		//i=65
+		//i=58
 		decodedData.Mcc, err = b2n.ParseBs2Uint16(bs, i)
-		//67
+		//60
 		i = i + 2
 		if err != nil {
 			return Decoded{}, fmt.Errorf("Decode error Mcc, %v", err)
 		}
 
 		// determine MNC Low Byte in packet
-		//i=67
+		//i=60
 		decodedData.MNCLowByte, err = b2n.ParseBs2Uint8(bs, i)
-		//68
+		//61
 		i = i + 1
 		if err != nil {
 			return Decoded{}, fmt.Errorf("Decode error MNCLowByte, %v", err)
 		}
 
 		// determine SerialNo of packet
-		//i=68
+		//i=61
 		decodedData.SerialNo, err = b2n.ParseBs2Uint8(bs, i)
 		if err != nil {
 			return Decoded{}, fmt.Errorf("Decode error SerialNo, %v", err)
 		}
-		//69
-		i = i + 1
+		// skip data appended after the serial number by a longer declared Length
+		i = start + frameLen
 		decoded.Data = append(decoded.Data, decodedData)
 	}
 	return decoded, nil
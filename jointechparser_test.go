@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
@@ -116,6 +117,11 @@ func TestDecodeSingleRecord(t *testing.T) {
 	//dec = 10342 hex = 0x2866
 	var expLAC uint16 = 10342
 	assert.Equal(t, expLAC, decoded.Data[0].LAC())
+	ts, err := decoded.Data[0].Timestamp()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 4, 18, 16, 22, 59, 0, time.UTC), ts)
+	_, err = (&PALData{Date: "320421", Time: "162259"}).Timestamp()
+	assert.Error(t, err)
 	val := reflect.DeepEqual(decoded, expectedDecoded)
 	assert.True(t, val)
 	assert.EqualValues(t, expectedDecoded.Data, decoded.Data)
@@ -325,3 +331,67 @@ func TestDecodePosDataInTheMiddle(t *testing.T) {
 	const mcc uint16 = 460
 	assert.Equal(t, mcc, decoded.Data[0].Mcc)
 }
+
+func TestDecodeManualSizedPosData(t *testing.T) {
+	// 62 byte packets with reserved IMEI followed by a healthcheck, as received from JT701D
+	hexData := "2475003136201912003415072021495322349750113550364F006800000000050000000010E04F04440B321F00070F0F0F0F0F0F0F0F0F0F000001CC0002" +
+		"28373530303331333632302C404A5429" +
+		"2475003136201911003415072021502522348802113550231F008900000000050000000000E04F04440B321F00070F0F0F0F0F0F0F0F0F0F000001CC0060"
+	byteData, err := hex.DecodeString(hexData)
+	assert.NoError(t, err)
+
+	decoded, err := Decode(&byteData)
+	assert.NoError(t, err)
+	assert.True(t, decoded.ContainsHealthcheck)
+	assert.Equal(t, "7500313620", decoded.TerminalID)
+	assert.Empty(t, decoded.IMEI)
+	assert.Len(t, decoded.Data, 2)
+	assert.Equal(t, uint16(460), decoded.Data[0].Mcc)
+	assert.Equal(t, uint8(0x02), decoded.Data[0].SerialNo)
+	assert.Equal(t, uint8(0x60), decoded.Data[1].SerialNo)
+
+	// IMEI in BCD
+	withIMEI, _ := hex.DecodeString("2480006200111911003418042116225922348310113550543F12980000002D060000000020E028109228661F05010001868822040248195F000001CC0156")
+	decoded, err = Decode(&withIMEI)
+	assert.NoError(t, err)
+	assert.Equal(t, "868822040248195", decoded.IMEI)
+	assert.Equal(t, uint8(0x56), decoded.Data[0].SerialNo)
+
+	// data after the serial number counted by Length is skipped
+	longer := append([]byte{}, withIMEI...)
+	longer[9] = 0x36
+	longer = append(longer, 0xAA, 0xBB)
+	longer = append(longer, withIMEI...)
+	decoded, err = Decode(&longer)
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Data, 2)
+
+	truncated := withIMEI[:40]
+	_, err = Decode(&truncated)
+	assert.Error(t, err)
+}
+
+func TestPositionFrameLen(t *testing.T) {
+	header, _ := hex.DecodeString("24800062001119110034")
+	n, err := PositionFrameLen(header)
+	assert.NoError(t, err)
+	assert.Equal(t, 62, n)
+	assert.Equal(t, MinPositionFrameLen, n)
+
+	header[9] = 0x40
+	n, err = PositionFrameLen(header)
+	assert.NoError(t, err)
+	assert.Equal(t, 74, n)
+
+	for name, modify := range map[string]func(h []byte) []byte{
+		"short":      func(h []byte) []byte { return h[:9] },
+		"not 0x24":   func(h []byte) []byte { h[0] = 0x28; return h },
+		"non BCD id": func(h []byte) []byte { h[3] = 0x6A; return h },
+		"data type":  func(h []byte) []byte { h[7] = 0x15; return h },
+		"short data": func(h []byte) []byte { h[8], h[9] = 0x00, 0x33; return h },
+	} {
+		h, _ := hex.DecodeString("24800062001119110034")
+		_, err := PositionFrameLen(modify(h))
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,217 @@
+package jointechparser
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// LockEventSource is an event source type of P45 lock and unlock report
+type LockEventSource uint8
+
+const (
+	SourceRFIDAuthorized  LockEventSource = iota + 1 // Swiped RFID authorization card
+	SourceRFIDIllegal                                // Swiped illegal RFID card
+	SourceVehicleIDCard                              // Swiped vehicle ID card binding
+	SourceStaticPassword                             // Remote static password unlocking
+	SourceAutoLock                                   // Device locked automatically
+	SourceDynamicPassword                            // Remote dynamic password unlocking
+	SourceBluetooth                                  // Bluetooth unlocking with static or dynamic password
+)
+
+func (s LockEventSource) String() string {
+	switch s {
+	case SourceRFIDAuthorized:
+		return "RFIDAuthorized"
+	case SourceRFIDIllegal:
+		return "RFIDIllegal"
+	case SourceVehicleIDCard:
+		return "VehicleIDCard"
+	case SourceStaticPassword:
+		return "StaticPassword"
+	case SourceAutoLock:
+		return "AutoLock"
+	case SourceDynamicPassword:
+		return "DynamicPassword"
+	case SourceBluetooth:
+		return "Bluetooth"
+	}
+	return fmt.Sprintf("<unknown source: %d>", uint8(s))
+}
+
+// P45 unlock verification values of fence associated unlocking
+const (
+	VerificationNoFence      uint8 = 98 // Fence association is off, unlocked normally
+	VerificationOutsideFence uint8 = 99 // Fence association is on, unlocking refused outside the fence
+)
+
+// LockReport is P45 lock and unlock report generated immediately when the device is locked or unlocked
+type LockReport struct {
+	TerminalID     string
+	Date           string  // Date in DDMMYY format, UTC
+	Time           string  // Time in hhmmss format, UTC
+	Utime          uint64  // Unix time in seconds
+	Lat            float64 // Decimal degrees, negative for south
+	Lng            float64 // Decimal degrees, negative for west
+	Positioned     bool    // A means GPS positioning, V means no positioning
+	Speed          float64 // Speed in km/h
+	Direction      uint16  // Direction in degrees
+	Source         LockEventSource
+	Verification   uint8  // 1 verification passed, 0 refused, for RFID and dynamic password 1-10 fence ID, 98 or 99
+	Card           uint32 // Swiped RFID card number, 0 for password, bluetooth and automatic lock events
+	PasswordOK     bool   // Password was correct for password and bluetooth events
+	WrongPasswords uint8  // Number of incorrect password entries for password and bluetooth events
+	SerialNo       uint32 // Event serial number used as P69 response serial number
+	Mileage        uint32 // Mileage in km
+}
+
+// ParseLockReport parses P45 response, e.g.
+// (8000620011,P45,170720,020614,22.56035,N,114.01640,E,A,36,270,1,1,0008627839,0,0,24,5)
+func ParseLockReport(r Response) (LockReport, error) {
+	// fields may be appended after mileage in the future, so all fields are taken by position from the start
+	if err := r.expect("P45", 16); err != nil {
+		return LockReport{}, err
+	}
+	lr := LockReport{TerminalID: r.TerminalID, Date: r.Params[0], Time: r.Params[1], Positioned: r.Params[6] == "A"}
+
+	t, err := time.Parse("020106150405", lr.Date+lr.Time)
+	if err != nil {
+		return LockReport{}, fmt.Errorf("P45 date and time, %v", err)
+	}
+	lr.Utime = uint64(t.Unix())
+
+	if lr.Lat, err = parseHemisphere(r.Params[2], r.Params[3], "N", "S"); err != nil {
+		return LockReport{}, err
+	}
+	if lr.Lng, err = parseHemisphere(r.Params[4], r.Params[5], "E", "W"); err != nil {
+		return LockReport{}, err
+	}
+	speed, err := r.uint(7, 16)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.Speed = float64(speed)
+	direction, err := r.uint(8, 16)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.Direction = uint16(direction)
+	source, err := r.uint(9, 8)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.Source = LockEventSource(source)
+	verification, err := r.uint(10, 8)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.Verification = uint8(verification)
+	card, err := r.uint(11, 32)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.Card = uint32(card)
+	if lr.PasswordOK, err = r.bool(12); err != nil {
+		return LockReport{}, err
+	}
+	wrong, err := r.uint(13, 8)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.WrongPasswords = uint8(wrong)
+	serial, err := r.uint(14, 32)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.SerialNo = uint32(serial)
+	mileage, err := r.uint(15, 32)
+	if err != nil {
+		return LockReport{}, err
+	}
+	lr.Mileage = uint32(mileage)
+	return lr, nil
+}
+
+// parseHemisphere parses decimal degrees and turns them negative for the negative hemisphere
+func parseHemisphere(value, hemisphere, positive, negative string) (float64, error) {
+	deg, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %q, %v", value, err)
+	}
+	switch hemisphere {
+	case positive:
+	case negative:
+		deg = -deg
+	default:
+		return 0, fmt.Errorf("invalid hemisphere %q, want %s or %s", hemisphere, positive, negative)
+	}
+	return deg, nil
+}
+
+// UnlockAllowed reports whether the report is a successful unlock
+func (lr *LockReport) UnlockAllowed() bool {
+	switch lr.Source {
+	case SourceRFIDAuthorized, SourceDynamicPassword:
+		return lr.Verification >= 1 && lr.Verification <= MaxGeofences || lr.Verification == VerificationNoFence
+	case SourceStaticPassword, SourceBluetooth:
+		return lr.Verification == 1
+	}
+	return false
+}
+
+// FenceID returns ID of fence the device was unlocked in, 0 when unlocking is not associated with a fence
+func (lr *LockReport) FenceID() uint8 {
+	if lr.Source != SourceRFIDAuthorized && lr.Source != SourceDynamicPassword {
+		return 0
+	}
+	if lr.Verification >= 1 && lr.Verification <= MaxGeofences {
+		return lr.Verification
+	}
+	return 0
+}
+
+// RetrieveReports returns P19 command obtaining positioning data and lock and unlock reports over serial port.
+// P19 is available only in customized firmware
+func RetrieveReports() Command {
+	return NewCommand("P19")
+}
+
+// ParseRetrievedReports parses P19 output. Its format is customized and not described in the manual, the parser
+// expects position data and P45 reports in the format they are pushed over GPRS. Line breaks and other bytes
+// between packets are skipped, so on demand and pushed reports end up in the same Decoded fields. A 0x24 byte
+// starts position data only when followed by a valid header, e.g. NMEA sentences starting with $ are skipped,
+// the packet size is taken from the Length declared by the header
+func ParseRetrievedReports(bs []byte) (Decoded, error) {
+	frames := make([]byte, 0, len(bs))
+	for i := 0; i < len(bs); {
+		switch bs[i] {
+		case 0x24:
+			n, err := PositionFrameLen(bs[i:min(i+PositionHeaderLen, len(bs))])
+			if err != nil || i+n > len(bs) {
+				i++
+				continue
+			}
+			if hasLegacyIMEI(bs[i:], n) {
+				n += legacyIMEILen
+			}
+			frames = append(frames, bs[i:i+n]...)
+			i += n
+		case 0x28:
+			end := i
+			for end < len(bs) && bs[end] != 0x29 {
+				end++
+			}
+			if end == len(bs) {
+				return Decoded{}, fmt.Errorf("unterminated packet %q", bs[i:])
+			}
+			frames = append(frames, bs[i:end+1]...)
+			i = end + 1
+		default:
+			i++
+		}
+	}
+	if len(frames) == 0 {
+		return Decoded{}, fmt.Errorf("no position data or reports in P19 output")
+	}
+	return Decode(&frames)
+}
@@ -0,0 +1,109 @@
+package jointechparser
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLockReport(t *testing.T) {
+	bs, _ := hex.DecodeString("28383030303632303031312C5034352C3137303732302C3032303631342C32322E35363033352C4E2C3131342E30313634302C452C412C33362C3237302C312C312C303030383632373833392C302C302C32342C3529")
+	r, err := ParseResponse(bs)
+	assert.NoError(t, err)
+	lr, err := ParseLockReport(r)
+	assert.NoError(t, err)
+	assert.Equal(t, LockReport{
+		TerminalID:   "8000620011",
+		Date:         "170720",
+		Time:         "020614",
+		Utime:        1594951574,
+		Lat:          22.56035,
+		Lng:          114.0164,
+		Positioned:   true,
+		Speed:        36,
+		Direction:    270,
+		Source:       SourceRFIDAuthorized,
+		Verification: 1,
+		Card:         8627839,
+		SerialNo:     24,
+		Mileage:      5,
+	}, lr)
+	assert.True(t, lr.UnlockAllowed())
+	assert.Equal(t, uint8(1), lr.FenceID())
+	assert.Equal(t, "RFIDAuthorized", lr.Source.String())
+
+	for _, c := range []struct {
+		report  string
+		allowed bool
+		fence   uint8
+	}{
+		{"(8000400055,P45,070121,074116,22.58071,N,113.91734,E,A,0,0,6,98,0000000000,1,0,13,0)", true, 0},
+		{"(8000400055,P45,060121,081257,22.58047,N,113.91753,E,A,0,0,4,1,0000000000,1,0,5,58)", true, 0},
+		{"(8000400055,P45,040121,104728,22.55801,N,114.00846,E,A,0,244,1,99,0008627839,0,0,2,29)", false, 0},
+		{"(8000400055,P45,060121,081012,22.58080,N,113.91751,E,A,0,0,5,0,0000000000,0,0,3,58)", false, 0},
+		// fields appended after mileage are ignored
+		{"(8000400055,P45,060121,081012,22.58080,S,113.91751,W,V,0,0,5,0,0000000000,0,0,3,58,1)", false, 0},
+	} {
+		r, err := ParseResponse([]byte(c.report))
+		assert.NoError(t, err)
+		lr, err := ParseLockReport(r)
+		assert.NoError(t, err, c.report)
+		assert.Equal(t, c.allowed, lr.UnlockAllowed(), c.report)
+		assert.Equal(t, c.fence, lr.FenceID(), c.report)
+	}
+
+	for _, s := range []string{
+		"(8000400055,P45,060121,081012,22.58080,N,113.91751,E,A,0,0,5,0,0000000000,0,0,3)",
+		"(8000400055,P45,320121,081012,22.58080,N,113.91751,E,A,0,0,5,0,0000000000,0,0,3,58)",
+		"(8000400055,P45,060121,081012,22.58080,X,113.91751,E,A,0,0,5,0,0000000000,0,0,3,58)",
+		"(8000400055,P45,060121,081012,22.58080,N,113.91751,E,A,0,0,5,0,0000000000,2,0,3,58)",
+	} {
+		r, _ := ParseResponse([]byte(s))
+		_, err := ParseLockReport(r)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseRetrievedReports(t *testing.T) {
+	assert.Equal(t, "(P19)", RetrieveReports().String())
+
+	position, _ := hex.DecodeString("2480006200111911003418042116225922348310113550543F12980000002D060000000020E028109228661F05010001868822040248195F000001CC0156")
+
+	var bs []byte
+	bs = append(bs, position...)
+	bs = append(bs, "\r\n(8000620011,P45,170720,020614,22.56035,N,114.01640,E,A,36,270,1,1,0008627839,0,0,24,5)\r\n"...)
+	bs = append(bs, position...)
+
+	decoded, err := ParseRetrievedReports(bs)
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Data, 2)
+	assert.Len(t, decoded.LockReports, 1)
+	assert.Empty(t, decoded.Responses)
+
+	pushed, err := Decode(&position)
+	assert.NoError(t, err)
+	assert.Equal(t, pushed.Data[0], decoded.Data[0])
+
+	// stray $ of NMEA sentence does not swallow following packets
+	bs = append([]byte("$GPRMC,162259.00,A,2234.8310,N,11355.0543,E,0.0,0.0,180421,,,A*6B\r\n"), bs...)
+	decoded, err = ParseRetrievedReports(bs)
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Data, 2)
+	assert.Len(t, decoded.LockReports, 1)
+	_, err = ParseRetrievedReports(append([]byte("$GPGGA\r\n"), position[:40]...))
+	assert.Error(t, err)
+
+	// packet size is taken from the declared Length
+	longer := append([]byte{}, position...)
+	longer[9] = 0x36
+	longer = append(longer, 0xAA, 0xBB)
+	decoded, err = ParseRetrievedReports(append(longer, position...))
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Data, 2)
+
+	_, err = ParseRetrievedReports([]byte("\r\n"))
+	assert.Error(t, err)
+	_, err = ParseRetrievedReports([]byte("(8000620011,P45,170720"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,358 @@
+package jointechparser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxFTPFieldLen = 50
+	otaFields      = 4 // TerminalID,1,001,OTA,9 prefix without terminal ID
+)
+
+// OTA-9 operation modes
+const (
+	OTAQuery  uint8 = 0
+	OTASet    uint8 = 1
+	OTACancel uint8 = 2
+)
+
+// OTAStatus is the result code of OTA-9 response
+type OTAStatus uint8
+
+const (
+	OTAOk         OTAStatus = iota // Operation successful
+	OTAUpgrading                   // Device is already upgrading
+	OTAInstalling                  // Device is being upgraded, FTP upgrade finishes once it completes with success or failure
+	OTALowPower                    // FTP failed to start, no upgrade under low power
+	OTABusy                        // Other upgrade is being performed
+)
+
+func (s OTAStatus) String() string {
+	switch s {
+	case OTAOk:
+		return "Ok"
+	case OTAUpgrading:
+		return "Upgrading"
+	case OTAInstalling:
+		return "Installing"
+	case OTALowPower:
+		return "LowPower"
+	case OTABusy:
+		return "Busy"
+	}
+	return fmt.Sprintf("<unknown status: %d>", uint8(s))
+}
+
+// OTARequest describes where the device downloads firmware from over FTP
+type OTARequest struct {
+	Host     string // FTP server IP address or domain
+	Port     uint16 // FTP server TCP port
+	User     string // FTP login username
+	Password string // FTP login password
+	File     string // Firmware file path on the FTP server, e.g. JT701_19.bin
+	Size     uint32 // Total bytes of the firmware file
+	Checksum uint32 // Sum of all bytes of the firmware file
+}
+
+// FirmwareChecksum returns total bytes and checksum of firmware file as expected by OTA-9
+func FirmwareChecksum(data []byte) (size uint32, checksum uint32) {
+	for _, b := range data {
+		checksum += uint32(b)
+	}
+	return uint32(len(data)), checksum
+}
+
+// Validate checks FTP host, credentials and file path
+func (o *OTARequest) Validate() error {
+	if err := validateHost(o.Host); err != nil {
+		return err
+	}
+	if o.Port == 0 {
+		return fmt.Errorf("invalid FTP port 0")
+	}
+	if o.User == "" {
+		return fmt.Errorf("empty FTP username")
+	}
+	for _, f := range []string{o.User, o.Password, o.File} {
+		if len(f) > maxFTPFieldLen {
+			return fmt.Errorf("FTP value %q is longer than %d characters", f, maxFTPFieldLen)
+		}
+		if strings.ContainsAny(f, "(),=") {
+			return fmt.Errorf("FTP value %q contains reserved characters", f)
+		}
+		for _, r := range f {
+			if r < 0x21 || r > 0x7E {
+				return fmt.Errorf("FTP value %q contains character %q", f, r)
+			}
+		}
+	}
+	if o.File == "" || strings.HasSuffix(o.File, "/") {
+		return fmt.Errorf("invalid firmware file %q", o.File)
+	}
+	for _, part := range strings.Split(o.File, "/") {
+		if part == ".." {
+			return fmt.Errorf("invalid firmware file %q, parent directory is not allowed", o.File)
+		}
+	}
+	if o.Size == 0 {
+		return fmt.Errorf("empty firmware file size")
+	}
+	return nil
+}
+
+func (o *OTARequest) params() []string {
+	return []string{
+		o.Host,
+		strconv.Itoa(int(o.Port)),
+		o.User,
+		o.Password,
+		o.File,
+		formatUint(uint64(o.Size)),
+		formatUint(uint64(o.Checksum)),
+	}
+}
+
+// otaCommand returns OTA-9 command, OTA commands are addressed by terminal ID which takes place of the command word
+func otaCommand(terminalID string, mode uint8, params ...string) Command {
+	return NewCommand(terminalID, append([]string{"1", "001", "OTA", "9", strconv.Itoa(int(mode))}, params...)...)
+}
+
+// UpgradeFirmware returns OTA-9 command starting firmware upgrade from FTP server,
+// e.g. (8130630001,1,001,OTA,9,1,222.252.17.214,10021,test1,Ab123456,JT701_19.bin,101764,9585478)
+func UpgradeFirmware(terminalID string, req OTARequest) (Command, error) {
+	if !isDigits(terminalID, 10) {
+		return Command{}, fmt.Errorf("invalid terminal ID %q, want 10 digits", terminalID)
+	}
+	if err := req.Validate(); err != nil {
+		return Command{}, err
+	}
+	return otaCommand(terminalID, OTASet, req.params()...), nil
+}
+
+// QueryUpgrade returns OTA-9 command querying firmware upgrade state
+func QueryUpgrade(terminalID string) Command {
+	return otaCommand(terminalID, OTAQuery)
+}
+
+// CancelUpgrade returns OTA-9 command cancelling firmware upgrade
+func CancelUpgrade(terminalID string) Command {
+	return otaCommand(terminalID, OTACancel)
+}
+
+// OTAReply is OTA-9 response reported by the device
+type OTAReply struct {
+	TerminalID string
+	Request    OTARequest // FTP parameters echoed by the device, empty when not reported
+	Status     OTAStatus
+}
+
+// isOTAReply reports whether bracketed packet is OTA-9 response
+func isOTAReply(bs []byte) bool {
+	fields := bytes.SplitN(bs, []byte{0x2C}, otaFields+2)
+	return len(fields) > otaFields && string(fields[3]) == "OTA" && strings.TrimSuffix(string(fields[4]), ")") == "9"
+}
+
+// ParseOTAReply takes a single OTA-9 response packet including the enclosing brackets and returns OTAReply,
+// e.g. (8130630001,1,001,OTA,9,222.252.17.214,10021,test1,Ab123456,JT701_19.bin,101764,9585478,0)
+func ParseOTAReply(bs []byte) (OTAReply, error) {
+	if len(bs) < 2 || bs[0] != 0x28 || bs[len(bs)-1] != 0x29 {
+		return OTAReply{}, fmt.Errorf("%q is not a JT command response", bs)
+	}
+	fields := strings.Split(string(bs[1:len(bs)-1]), ",")
+	if len(fields) < otaFields+2 || fields[3] != "OTA" || fields[4] != "9" {
+		return OTAReply{}, fmt.Errorf("%q is not an OTA-9 response", bs)
+	}
+	if !isDigits(fields[0], 10) {
+		return OTAReply{}, fmt.Errorf("invalid terminal ID %q, want 10 digits", fields[0])
+	}
+	for n := range fields {
+		fields[n] = string(unescape([]byte(fields[n])))
+	}
+
+	// query and cancel replies may carry the status only
+	params := fields[otaFields+1:]
+	status, err := strconv.ParseUint(strings.TrimSpace(params[len(params)-1]), 10, 8)
+	if err != nil {
+		return OTAReply{}, fmt.Errorf("OTA-9 status, %v", err)
+	}
+	reply := OTAReply{TerminalID: fields[0], Status: OTAStatus(status)}
+	if len(params) < 8 {
+		return reply, nil
+	}
+	port, err := strconv.ParseUint(params[1], 10, 16)
+	if err != nil {
+		return OTAReply{}, fmt.Errorf("OTA-9 FTP port, %v", err)
+	}
+	size, err := strconv.ParseUint(params[5], 10, 32)
+	if err != nil {
+		return OTAReply{}, fmt.Errorf("OTA-9 file size, %v", err)
+	}
+	checksum, err := strconv.ParseUint(params[6], 10, 32)
+	if err != nil {
+		return OTAReply{}, fmt.Errorf("OTA-9 checksum, %v", err)
+	}
+	reply.Request = OTARequest{
+		Host:     params[0],
+		Port:     uint16(port),
+		User:     params[2],
+		Password: params[3],
+		File:     params[4],
+		Size:     uint32(size),
+		Checksum: uint32(checksum),
+	}
+	return reply, nil
+}
+
+// OTAState is a stage of firmware upgrade of a single device
+type OTAState int
+
+const (
+	OTARequested   OTAState = iota + 1 // OTA-9 command was created, no reply yet
+	OTADownloading                     // Device accepted the upgrade and downloads firmware
+	OTAVerifying                       // Device is being upgraded, new version has to be confirmed by P01
+	OTAUpgraded                        // P01 reports the target firmware version
+	OTAFailed                          // Device refused the upgrade, or the job was cancelled or expired
+)
+
+func (s OTAState) String() string {
+	switch s {
+	case OTARequested:
+		return "Requested"
+	case OTADownloading:
+		return "Downloading"
+	case OTAVerifying:
+		return "Verifying"
+	case OTAUpgraded:
+		return "Upgraded"
+	case OTAFailed:
+		return "Failed"
+	}
+	return fmt.Sprintf("<unknown state: %d>", int(s))
+}
+
+// OTAJob tracks firmware upgrade of a single device from OTA-9 command to P01 version confirmation
+type OTAJob struct {
+	TerminalID    string
+	Request       OTARequest
+	TargetVersion string // Expected DeviceInfo.FirmwareVersion after upgrade, e.g. 20210311
+	State         OTAState
+	Status        OTAStatus // Last OTA-9 status reported by the device
+	Downloaded    uint32    // Firmware bytes sent to the device by the FTP server
+	Version       string    // Firmware version reported by P01
+	Err           error     // Reason of failure
+}
+
+// NewOTAJob validates request and returns job in OTARequested state
+func NewOTAJob(terminalID string, req OTARequest, targetVersion string) (*OTAJob, error) {
+	if _, err := UpgradeFirmware(terminalID, req); err != nil {
+		return nil, err
+	}
+	if targetVersion == "" {
+		return nil, fmt.Errorf("empty target firmware version")
+	}
+	return &OTAJob{TerminalID: terminalID, Request: req, TargetVersion: targetVersion, State: OTARequested}, nil
+}
+
+// Command returns OTA-9 command starting the upgrade
+func (j *OTAJob) Command() Command {
+	cmd, _ := UpgradeFirmware(j.TerminalID, j.Request)
+	return cmd
+}
+
+// VerifyCommand returns P01 command confirming the firmware version after upgrade
+func (j *OTAJob) VerifyCommand() Command {
+	return NewCommand("P01")
+}
+
+// Done reports whether the job reached final state
+func (j *OTAJob) Done() bool {
+	return j.State == OTAUpgraded || j.State == OTAFailed
+}
+
+// ApplyReply moves job according to OTA-9 response
+func (j *OTAJob) ApplyReply(r OTAReply) error {
+	if r.TerminalID != j.TerminalID {
+		return fmt.Errorf("OTA-9 response is for terminal %s, want %s", r.TerminalID, j.TerminalID)
+	}
+	if j.Done() {
+		return nil
+	}
+	j.Status = r.Status
+	switch r.Status {
+	case OTAOk, OTAUpgrading:
+		if j.State == OTARequested {
+			j.State = OTADownloading
+		}
+	case OTAInstalling:
+		j.State = OTAVerifying
+	case OTALowPower:
+		j.fail(fmt.Errorf("FTP failed to start, battery is low"))
+	case OTABusy:
+		j.fail(fmt.Errorf("device is busy with other upgrade"))
+	default:
+		return fmt.Errorf("unknown OTA-9 status %d", r.Status)
+	}
+	return nil
+}
+
+// ApplyResponse confirms the upgrade with P01 response. P01 reporting the target version completes the job
+// in any state, other versions are only recorded as the device may still be upgrading, see Expire
+func (j *OTAJob) ApplyResponse(r Response) error {
+	if r.TerminalID != j.TerminalID {
+		return fmt.Errorf("%s response is for terminal %s, want %s", r.Word, r.TerminalID, j.TerminalID)
+	}
+	info := DeviceInfo{TerminalID: j.TerminalID}
+	if err := info.Apply(r); err != nil {
+		return err
+	}
+	if r.Word != "P01" {
+		return fmt.Errorf("unexpected command word %s for OTA job", r.Word)
+	}
+	if j.Done() {
+		return nil
+	}
+	j.Version = info.FirmwareVersion
+	if info.FirmwareVersion == j.TargetVersion {
+		j.State = OTAUpgraded
+	}
+	return nil
+}
+
+// Expire fails the job which was not confirmed in time, callers re-query the version with VerifyCommand
+// until their deadline passes
+func (j *OTAJob) Expire() {
+	switch {
+	case j.Done():
+	case j.Version != "":
+		j.fail(fmt.Errorf("device reports firmware %s, want %s", j.Version, j.TargetVersion))
+	default:
+		j.fail(fmt.Errorf("firmware %s was not confirmed", j.TargetVersion))
+	}
+}
+
+// Progress records firmware bytes downloaded by the device, the first download moves the job to OTADownloading
+func (j *OTAJob) Progress(sent uint32) {
+	if j.Done() {
+		return
+	}
+	j.Downloaded = sent
+	if j.State == OTARequested {
+		j.State = OTADownloading
+	}
+}
+
+// Cancel returns OTA-9 cancel command and fails the job
+func (j *OTAJob) Cancel() Command {
+	if !j.Done() {
+		j.fail(fmt.Errorf("upgrade cancelled"))
+	}
+	return CancelUpgrade(j.TerminalID)
+}
+
+func (j *OTAJob) fail(err error) {
+	j.State = OTAFailed
+	j.Err = err
+}
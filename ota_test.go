@@ -0,0 +1,143 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOTARequest = OTARequest{
+	Host:     "222.252.17.214",
+	Port:     10021,
+	User:     "test1",
+	Password: "Ab123456",
+	File:     "JT701_19.bin",
+	Size:     101764,
+	Checksum: 9585478,
+}
+
+func TestUpgradeFirmware(t *testing.T) {
+	cmd, err := UpgradeFirmware("8130630001", testOTARequest)
+	assert.NoError(t, err)
+	assert.Equal(t, "(8130630001,1,001,OTA,9,1,222.252.17.214,10021,test1,Ab123456,JT701_19.bin,101764,9585478)", cmd.String())
+	assert.Equal(t, "(8130630001,1,001,OTA,9,0)", QueryUpgrade("8130630001").String())
+	assert.Equal(t, "(8130630001,1,001,OTA,9,2)", CancelUpgrade("8130630001").String())
+
+	for _, mutate := range []func(o *OTARequest){
+		func(o *OTARequest) { o.Host = "ftp..example.com" },
+		func(o *OTARequest) { o.Port = 0 },
+		func(o *OTARequest) { o.User = "" },
+		func(o *OTARequest) { o.Password = "pass,word" },
+		func(o *OTARequest) { o.File = "" },
+		func(o *OTARequest) { o.File = "fw/" },
+		func(o *OTARequest) { o.File = "../etc/passwd" },
+		func(o *OTARequest) { o.File = "my firmware.bin" },
+		func(o *OTARequest) { o.Size = 0 },
+	} {
+		req := testOTARequest
+		mutate(&req)
+		_, err := UpgradeFirmware("8130630001", req)
+		assert.Error(t, err, req)
+	}
+	_, err = UpgradeFirmware("813063", testOTARequest)
+	assert.Error(t, err)
+}
+
+func TestFirmwareChecksum(t *testing.T) {
+	size, sum := FirmwareChecksum([]byte{0x01, 0xFF, 0x10})
+	assert.Equal(t, uint32(3), size)
+	assert.Equal(t, uint32(0x110), sum)
+}
+
+func TestParseOTAReply(t *testing.T) {
+	r, err := ParseOTAReply([]byte("(8130630001,1,001,OTA,9,222.252.17.214,10021,test1,Ab123456,JT701_19.bin,101764,9585478,0)"))
+	assert.NoError(t, err)
+	assert.Equal(t, OTAReply{TerminalID: "8130630001", Request: testOTARequest, Status: OTAOk}, r)
+
+	r, err = ParseOTAReply([]byte("(8130630001,1,001,OTA,9,4)"))
+	assert.NoError(t, err)
+	assert.Equal(t, OTAReply{TerminalID: "8130630001", Status: OTABusy}, r)
+	assert.Equal(t, "Busy", r.Status.String())
+
+	for _, s := range []string{
+		"(8130630001,P01,JT701D,41%)",
+		"(8130630001,1,001,OTA,9)",
+		"(8130630001,1,001,OTA,9,x)",
+		"(81306300,1,001,OTA,9,0)",
+	} {
+		_, err := ParseOTAReply([]byte(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDecodeOTAReply(t *testing.T) {
+	bs := []byte("(8130630001,1,001,OTA,9,222.252.17.214,10021,test1,Ab123456,JT701_19.bin,101764,9585478,1)(8130630001,P01,JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7,41%)")
+	decoded, err := Decode(&bs)
+	assert.NoError(t, err)
+	assert.Len(t, decoded.OTAReplies, 1)
+	assert.Equal(t, OTAUpgrading, decoded.OTAReplies[0].Status)
+	assert.Len(t, decoded.Responses, 1)
+}
+
+func TestOTAJob(t *testing.T) {
+	job, err := NewOTAJob("8130630001", testOTARequest, "20220105")
+	assert.NoError(t, err)
+	assert.Equal(t, OTARequested, job.State)
+	assert.Equal(t, "(8130630001,1,001,OTA,9,1,222.252.17.214,10021,test1,Ab123456,JT701_19.bin,101764,9585478)", job.Command().String())
+
+	p01 := func(fw string) Response {
+		r, err := ParseResponse([]byte("(8130630001,P01," + fw + ",80%)"))
+		assert.NoError(t, err)
+		return r
+	}
+
+	assert.NoError(t, job.ApplyReply(OTAReply{TerminalID: "8130630001", Status: OTAOk}))
+	assert.Equal(t, OTADownloading, job.State)
+	// old version reported while downloading is not a failure
+	assert.NoError(t, job.ApplyResponse(p01("JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7")))
+	assert.Equal(t, OTADownloading, job.State)
+	assert.NoError(t, job.ApplyReply(OTAReply{TerminalID: "8130630001", Status: OTAInstalling}))
+	assert.Equal(t, OTAVerifying, job.State)
+	assert.Equal(t, "(P01)", job.VerifyCommand().String())
+	assert.NoError(t, job.ApplyResponse(p01("JT701D_20220105_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7")))
+	assert.Equal(t, OTAUpgraded, job.State)
+	assert.True(t, job.Done())
+	assert.Equal(t, "20220105", job.Version)
+
+	// old version reported while the device is being upgraded fails the job only once it expires
+	job, _ = NewOTAJob("8130630001", testOTARequest, "20220105")
+	assert.NoError(t, job.ApplyReply(OTAReply{TerminalID: "8130630001", Status: OTAInstalling}))
+	assert.NoError(t, job.ApplyResponse(p01("JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7")))
+	assert.Equal(t, OTAVerifying, job.State)
+	assert.Equal(t, "20210311", job.Version)
+	job.Expire()
+	assert.Equal(t, OTAFailed, job.State)
+	assert.EqualError(t, job.Err, "device reports firmware 20210311, want 20220105")
+
+	job, _ = NewOTAJob("8130630001", testOTARequest, "20220105")
+	job.Expire()
+	assert.EqualError(t, job.Err, "firmware 20220105 was not confirmed")
+
+	job, _ = NewOTAJob("8130630001", testOTARequest, "20220105")
+	assert.NoError(t, job.ApplyReply(OTAReply{TerminalID: "8130630001", Status: OTALowPower}))
+	assert.Equal(t, OTAFailed, job.State)
+	assert.Error(t, job.ApplyReply(OTAReply{TerminalID: "8000620011", Status: OTAOk}))
+
+	job, _ = NewOTAJob("8130630001", testOTARequest, "20220105")
+	assert.Equal(t, "(8130630001,1,001,OTA,9,2)", job.Cancel().String())
+	assert.Equal(t, OTAFailed, job.State)
+
+	_, err = NewOTAJob("8130630001", testOTARequest, "")
+	assert.Error(t, err)
+}
+
+func TestOTAJobProgress(t *testing.T) {
+	job, _ := NewOTAJob("8130630001", testOTARequest, "20220105")
+	job.Progress(4096)
+	assert.Equal(t, OTADownloading, job.State)
+	assert.Equal(t, uint32(4096), job.Downloaded)
+
+	job.Cancel()
+	job.Progress(8192)
+	assert.Equal(t, uint32(4096), job.Downloaded)
+}
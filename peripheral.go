@@ -0,0 +1,54 @@
+package jointechparser
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Query, bind and delete commands of JT709 slave locks and JT126 sensors and WLNET,5 payload layout are described
+// in JT126 Temperature Sensor and JT709 Sub Lock Integration Manual, which is not in the doc folder. Builders and
+// reply parsers are not provided until the manual is available, WLNET,5 data is passed through as raw payload.
+// Only the data serial number is decoded.
+
+// peripheralSerialOffset is the offset of data serial number in WLNET,5 payload. It is not documented, it comes from
+// the data sample of WLNET,5 Peripheral data in JT701D Protocol Manual V1.4, page 18, answered by (P69,0,18)
+const peripheralSerialOffset = 30
+
+// PeripheralData is WLNET,5 data reported by master lock on behalf of bound JT709 or JT126
+type PeripheralData struct {
+	TerminalID string
+	SerialNo   uint8  // Data serial number used as P69 response serial number
+	HasSerial  bool   // Payload is long enough to carry SerialNo, packets without it are not acknowledged
+	Payload    []byte // Binary peripheral data following WLNET,5, with escape characters restored
+}
+
+// isPeripheralData reports whether bracketed packet is WLNET,5 peripheral data
+func isPeripheralData(bs []byte) bool {
+	fields := bytes.SplitN(bs, []byte{0x2C}, 6)
+	return len(fields) > 4 && string(fields[3]) == "WLNET" && string(bytes.TrimSuffix(fields[4], []byte{0x29})) == "5"
+}
+
+// ParsePeripheralData takes a single WLNET,5 packet including the enclosing brackets and returns PeripheralData,
+// e.g. (8130630001,1,110,WLNET,5,2,...binary data...)
+func ParsePeripheralData(bs []byte) (PeripheralData, error) {
+	if len(bs) < 2 || bs[0] != 0x28 || bs[len(bs)-1] != 0x29 {
+		return PeripheralData{}, fmt.Errorf("%q is not a JT peripheral data packet", bs)
+	}
+	fields := bytes.SplitN(bs[1:len(bs)-1], []byte{0x2C}, 6)
+	if len(fields) < 6 || string(fields[3]) != "WLNET" || string(fields[4]) != "5" {
+		return PeripheralData{}, fmt.Errorf("%q is not WLNET,5 peripheral data", bs)
+	}
+	if !isDigits(string(fields[0]), 10) {
+		return PeripheralData{}, fmt.Errorf("invalid terminal ID %q, want 10 digits", fields[0])
+	}
+	payload := unescape(fields[5])
+	p := PeripheralData{
+		TerminalID: string(fields[0]),
+		Payload:    append([]byte(nil), payload...),
+	}
+	if len(payload) > peripheralSerialOffset {
+		p.SerialNo = payload[peripheralSerialOffset]
+		p.HasSerial = true
+	}
+	return p, nil
+}
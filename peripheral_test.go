@@ -0,0 +1,47 @@
+package jointechparser
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeripheralData(t *testing.T) {
+	bs, _ := hex.DecodeString("28383133303633303030312C312C3131302C574C4E45542C352C322C19042111353422348344113550520F0000190421113533E0172600041201681057040040000000310029")
+	p, err := ParsePeripheralData(bs)
+	assert.NoError(t, err)
+	assert.Equal(t, "8130630001", p.TerminalID)
+	assert.Equal(t, []byte("2,"), p.Payload[:2])
+	assert.Len(t, p.Payload, 43)
+	assert.Equal(t, uint8(18), p.SerialNo)
+	assert.True(t, p.HasSerial)
+
+	escaped := append(append([]byte("(8130630001,1,110,WLNET,5,2,\x3D\x14\x3D\x11"), bs[30:len(bs)-1]...), 0x29)
+	p, err = ParsePeripheralData(escaped)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2,),"), p.Payload[:4])
+	assert.Equal(t, uint8(18), p.SerialNo)
+
+	// payload too short to carry the data serial number
+	p, err = ParsePeripheralData([]byte("(8130630001,1,110,WLNET,5,2,\x3D\x14\x3D\x11)"))
+	assert.NoError(t, err)
+	assert.False(t, p.HasSerial)
+	assert.Equal(t, []byte("2,),"), p.Payload)
+
+	_, err = ParsePeripheralData([]byte("(8130630001,P45,1)"))
+	assert.Error(t, err)
+
+	decoded, err := Decode(&bs)
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Peripherals, 1)
+	assert.Empty(t, decoded.Responses)
+
+	// packets failing to parse are passed through as received
+	bad := []byte("(813063000X,1,110,WLNET,5,2,\x19)(8130630001,1,001,OTA,9,X)")
+	decoded, err = Decode(&bad)
+	assert.NoError(t, err)
+	assert.Empty(t, decoded.Peripherals)
+	assert.Empty(t, decoded.OTAReplies)
+	assert.Equal(t, [][]byte{[]byte("(813063000X,1,110,WLNET,5,2,\x19)"), []byte("(8130630001,1,001,OTA,9,X)")}, decoded.Unparsed)
+}
@@ -0,0 +1,37 @@
+package jointechparser
+
+import (
+	"strconv"
+	"time"
+)
+
+// Ack returns P69 command acknowledging position data, alarm data or P45 report with given serial number
+func Ack(serial uint32) Command {
+	return NewCommand("P69", "0", strconv.FormatUint(uint64(serial), 10))
+}
+
+// TimeSync returns P22 command answering P22,2 time synchronization request, e.g. (P22,150720164328)
+func TimeSync(t time.Time) Command {
+	return NewCommand("P22", t.UTC().Format("020106150405"))
+}
+
+// ConfirmDynamicPassword returns P52,2 command answering dynamic password report, the device keeps
+// reporting the password every minute until it is confirmed
+func ConfirmDynamicPassword(password string) Command {
+	return NewCommand("P52", "2", password)
+}
+
+// IsTimeSyncRequest reports whether response is P22,2 time synchronization request
+func IsTimeSyncRequest(r Response) bool {
+	// (8000620011,P22,2)
+	return r.Word == "P22" && len(r.Params) == 1 && r.Params[0] == "2"
+}
+
+// ParseDynamicPasswordReport parses P52,2 dynamic password report and returns the password
+func ParseDynamicPasswordReport(r Response) (string, bool) {
+	// (8000620011,P52,2,113271)
+	if r.Word != "P52" || len(r.Params) != 2 || r.Params[0] != "2" {
+		return "", false
+	}
+	return r.Params[1], true
+}
@@ -0,0 +1,28 @@
+package jointechparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlatformReplies(t *testing.T) {
+	assert.Equal(t, "(P69,0,24)", Ack(24).String())
+	at := time.Date(2020, 7, 15, 18, 43, 28, 0, time.FixedZone("CEST", 2*60*60))
+	assert.Equal(t, "(P22,150720164328)", TimeSync(at).String())
+	assert.Equal(t, "(P52,2,113271)", ConfirmDynamicPassword("113271").String())
+
+	r, _ := ParseResponse([]byte("(8000620011,P22,2)"))
+	assert.True(t, IsTimeSyncRequest(r))
+	r, _ = ParseResponse([]byte("(8000620011,P22,1)"))
+	assert.False(t, IsTimeSyncRequest(r))
+
+	r, _ = ParseResponse([]byte("(8000620011,P52,2,113271)"))
+	password, ok := ParseDynamicPasswordReport(r)
+	assert.True(t, ok)
+	assert.Equal(t, "113271", password)
+	r, _ = ParseResponse([]byte("(8000620011,P52,1,1,0)"))
+	_, ok = ParseDynamicPasswordReport(r)
+	assert.False(t, ok)
+}
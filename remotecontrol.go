@@ -0,0 +1,107 @@
+package jointechparser
+
+import (
+	"fmt"
+)
+
+// Confirmation is a token which has to be passed to builders of destructive commands
+type Confirmation string
+
+const (
+	// ConfirmFactoryReset confirms P13 restoring factory defaults
+	ConfirmFactoryReset Confirmation = "I understand the device loses its configuration"
+	// ConfirmPowerSwitchOff confirms P50 disabling the power key, the device can not be switched off by hand afterwards
+	ConfirmPowerSwitchOff Confirmation = "I understand the device can not be switched off by the power key"
+)
+
+// ControlOutcome is a typed result of remote control command reported by the device
+type ControlOutcome int
+
+const (
+	RestartAccepted ControlOutcome = iota + 1
+	SleepAccepted
+	FactoryResetAccepted
+	PowerSwitchEnabled
+	PowerSwitchDisabled
+)
+
+func (o ControlOutcome) String() string {
+	switch o {
+	case RestartAccepted:
+		return "RestartAccepted"
+	case SleepAccepted:
+		return "SleepAccepted"
+	case FactoryResetAccepted:
+		return "FactoryResetAccepted"
+	case PowerSwitchEnabled:
+		return "PowerSwitchEnabled"
+	case PowerSwitchDisabled:
+		return "PowerSwitchDisabled"
+	}
+	return fmt.Sprintf("<unknown outcome: %d>", int(o))
+}
+
+// Restart returns P15 command, the device restarts around 30 seconds later
+func Restart() Command {
+	return NewCommand("P15")
+}
+
+// ForceSleep returns P32 command, the device enters sleep mode around 30 seconds later
+func ForceSleep() Command {
+	return NewCommand("P32")
+}
+
+// FactoryReset returns P13 command restoring all parameters except IP, port, VIP numbers and APN to factory defaults
+func FactoryReset(confirm Confirmation) (Command, error) {
+	if confirm != ConfirmFactoryReset {
+		return Command{}, fmt.Errorf("factory reset requires ConfirmFactoryReset token")
+	}
+	return NewCommand("P13"), nil
+}
+
+// EnablePowerSwitch returns P50 command enabling the power key, which is the factory default
+func EnablePowerSwitch() Command {
+	return NewCommand("P50", "1", "1")
+}
+
+// DisablePowerSwitch returns P50 command disabling the power key
+func DisablePowerSwitch(confirm Confirmation) (Command, error) {
+	if confirm != ConfirmPowerSwitchOff {
+		return Command{}, fmt.Errorf("disabling power switch requires ConfirmPowerSwitchOff token")
+	}
+	return NewCommand("P50", "1", "0"), nil
+}
+
+// QueryPowerSwitch returns P50 command querying the power key state
+func QueryPowerSwitch() Command {
+	return NewCommand("P50", "0")
+}
+
+// ParseControlOutcome parses P13, P15, P32 or P50 response
+func ParseControlOutcome(r Response) (ControlOutcome, error) {
+	switch r.Word {
+	case "P15":
+		// (8130630001,P15)
+		return RestartAccepted, nil
+	case "P32":
+		// (8130630001,P32)
+		return SleepAccepted, nil
+	case "P13":
+		// (8130630001,P13)
+		return FactoryResetAccepted, nil
+	case "P50":
+		// (8130630001,P50,1)
+		if err := r.expect("P50", 1); err != nil {
+			return 0, err
+		}
+		enabled, err := r.bool(len(r.Params) - 1)
+		if err != nil {
+			return 0, err
+		}
+		if enabled {
+			return PowerSwitchEnabled, nil
+		}
+		return PowerSwitchDisabled, nil
+	}
+	return 0, fmt.Errorf("unexpected command word %s for remote control", r.Word)
+}
@@ -0,0 +1,52 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteControlCommands(t *testing.T) {
+	assert.Equal(t, "(P15)", Restart().String())
+	assert.Equal(t, "(P32)", ForceSleep().String())
+	assert.Equal(t, "(P50,1,1)", EnablePowerSwitch().String())
+	assert.Equal(t, "(P50,0)", QueryPowerSwitch().String())
+
+	_, err := FactoryReset("yes")
+	assert.Error(t, err)
+	_, err = FactoryReset(ConfirmPowerSwitchOff)
+	assert.Error(t, err)
+	cmd, err := FactoryReset(ConfirmFactoryReset)
+	assert.NoError(t, err)
+	assert.Equal(t, "(P13)", cmd.String())
+
+	_, err = DisablePowerSwitch("")
+	assert.Error(t, err)
+	cmd, err = DisablePowerSwitch(ConfirmPowerSwitchOff)
+	assert.NoError(t, err)
+	assert.Equal(t, "(P50,1,0)", cmd.String())
+}
+
+func TestParseControlOutcome(t *testing.T) {
+	for s, expected := range map[string]ControlOutcome{
+		"(8130630001,P15)":   RestartAccepted,
+		"(8130630001,P32)":   SleepAccepted,
+		"(8130630001,P13)":   FactoryResetAccepted,
+		"(8130630001,P50,1)": PowerSwitchEnabled,
+		"(8130630001,P50,0)": PowerSwitchDisabled,
+	} {
+		r, err := ParseResponse([]byte(s))
+		assert.NoError(t, err)
+		outcome, err := ParseControlOutcome(r)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, outcome, s)
+	}
+
+	r, _ := ParseResponse([]byte("(8130630001,P50)"))
+	_, err := ParseControlOutcome(r)
+	assert.Error(t, err)
+	r, _ = ParseResponse([]byte("(8130630001,P01,JT701D,41%)"))
+	_, err = ParseControlOutcome(r)
+	assert.Error(t, err)
+	assert.Equal(t, "PowerSwitchDisabled", PowerSwitchDisabled.String())
+}
@@ -0,0 +1,284 @@
+package jointechparser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	MaxRFIDCards        = 500 // Authorization cards supported by JT701D standard firmware
+	rfidCardsPerCommand = 20  // Max cards in one P41 command or query group
+	rfidCardGroups      = MaxRFIDCards / rfidCardsPerCommand
+)
+
+// P41 operation types
+const (
+	CardAdd       uint8 = 1
+	CardDelete    uint8 = 2
+	CardDeleteAll uint8 = 3
+)
+
+// CardSet is a set of RFID authorization card numbers
+type CardSet map[uint32]struct{}
+
+// NewCardSet returns CardSet containing given cards
+func NewCardSet(cards ...uint32) CardSet {
+	s := make(CardSet, len(cards))
+	for _, c := range cards {
+		s[c] = struct{}{}
+	}
+	return s
+}
+
+// Add puts cards into the set
+func (s CardSet) Add(cards ...uint32) {
+	for _, c := range cards {
+		s[c] = struct{}{}
+	}
+}
+
+// Has reports whether card is in the set
+func (s CardSet) Has(card uint32) bool {
+	_, ok := s[card]
+	return ok
+}
+
+// Sorted returns card numbers in ascending order
+func (s CardSet) Sorted() []uint32 {
+	cards := make([]uint32, 0, len(s))
+	for c := range s {
+		cards = append(cards, c)
+	}
+	sort.Slice(cards, func(i, j int) bool { return cards[i] < cards[j] })
+	return cards
+}
+
+// CardReply is a parsed P41 response to add or delete command
+type CardReply struct {
+	Operation uint8    // 1 add, 2 delete certain, 3 delete all
+	Count     int      // Cards added or cards left after delete
+	Cards     []uint32 // Cards added, empty for delete
+}
+
+// CardGroup is a parsed P41 response to group query
+type CardGroup struct {
+	Group int      // Queried group in range [1~25]
+	Cards []uint32 // Cards of the group, up to 20
+}
+
+// AddCards returns P41 commands adding cards in batches of 20
+func AddCards(cards ...uint32) ([]Command, error) {
+	return cardCommands(CardAdd, cards)
+}
+
+// DeleteCards returns P41 commands deleting cards in batches of 20
+func DeleteCards(cards ...uint32) ([]Command, error) {
+	return cardCommands(CardDelete, cards)
+}
+
+// DeleteAllCards returns P41 command deleting all authorization cards
+func DeleteAllCards() Command {
+	return NewCommand("P41", "1", strconv.Itoa(int(CardDeleteAll)))
+}
+
+// QueryCards returns P41 command querying card group in range [1~25], each group holds up to 20 cards
+func QueryCards(group int) (Command, error) {
+	if group < 1 || group > rfidCardGroups {
+		return Command{}, fmt.Errorf("invalid card group, want 1-%d, got %d", rfidCardGroups, group)
+	}
+	return NewCommand("P41", "0", strconv.Itoa(group)), nil
+}
+
+// QueryAllCards returns P41 commands querying all 25 card groups
+func QueryAllCards() []Command {
+	cmds := make([]Command, 0, rfidCardGroups)
+	for g := 1; g <= rfidCardGroups; g++ {
+		cmds = append(cmds, NewCommand("P41", "0", strconv.Itoa(g)))
+	}
+	return cmds
+}
+
+// SetCardRegistration returns P42 command enabling or disabling on-site card registration
+func SetCardRegistration(enabled bool) Command {
+	return NewCommand("P42", formatBool(enabled))
+}
+
+func cardCommands(op uint8, cards []uint32) ([]Command, error) {
+	if len(cards) == 0 {
+		return nil, fmt.Errorf("no cards given")
+	}
+	cmds := make([]Command, 0, (len(cards)+rfidCardsPerCommand-1)/rfidCardsPerCommand)
+	for i := 0; i < len(cards); i += rfidCardsPerCommand {
+		batch := cards[i:min(len(cards), i+rfidCardsPerCommand)]
+		params := []string{"1", strconv.Itoa(int(op)), strconv.Itoa(len(batch))}
+		for _, c := range batch {
+			if c == 0 {
+				return nil, fmt.Errorf("invalid card number 0")
+			}
+			params = append(params, formatCard(c))
+		}
+		cmds = append(cmds, NewCommand("P41", params...))
+	}
+	return cmds, nil
+}
+
+// SyncCards returns P41 commands turning the current device card list into the desired one
+func SyncCards(current, desired CardSet) ([]Command, error) {
+	if len(desired) > MaxRFIDCards {
+		return nil, fmt.Errorf("too many cards, want max %d, got %d", MaxRFIDCards, len(desired))
+	}
+	if len(desired) == 0 {
+		if len(current) == 0 {
+			return nil, nil
+		}
+		return []Command{DeleteAllCards()}, nil
+	}
+
+	var remove, add []uint32
+	for _, c := range current.Sorted() {
+		if !desired.Has(c) {
+			remove = append(remove, c)
+		}
+	}
+	for _, c := range desired.Sorted() {
+		if !current.Has(c) {
+			add = append(add, c)
+		}
+	}
+
+	var cmds []Command
+	// delete first so the device never runs over its capacity
+	if len(remove) > 0 {
+		del, err := DeleteCards(remove...)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, del...)
+	}
+	if len(add) > 0 {
+		ins, err := AddCards(add...)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, ins...)
+	}
+	return cmds, nil
+}
+
+// ParseCardReply parses P41 response to add or delete command of given operation type. The response does
+// not tell operations from queries, e.g. an add reply looks the same as the first group reply, so the caller
+// passes the operation it sent
+func ParseCardReply(r Response, op uint8) (CardReply, error) {
+	// (8130630001,P41,1,20,0002124750,...), (8130630001,P41,2,17) or (8130630001,P41,3,0)
+	if op < CardAdd || op > CardDeleteAll {
+		return CardReply{}, fmt.Errorf("invalid P41 operation type %d", op)
+	}
+	got, count, cards, err := parseCardResponse(r)
+	if err != nil {
+		return CardReply{}, err
+	}
+	if got != uint64(op) {
+		return CardReply{}, fmt.Errorf("P41 response is for operation %d, want %d", got, op)
+	}
+	if op == CardAdd && count != len(cards) || op != CardAdd && len(cards) > 0 {
+		return CardReply{}, fmt.Errorf("P41 reports %d cards, got %d", count, len(cards))
+	}
+	return CardReply{Operation: op, Count: count, Cards: cards}, nil
+}
+
+// ParseCardGroup parses P41 response to query of given card group
+func ParseCardGroup(r Response, group int) (CardGroup, error) {
+	// (8130630001,P41,1,17,0006734739,...)
+	got, count, cards, err := parseCardResponse(r)
+	if err != nil {
+		return CardGroup{}, err
+	}
+	if got != uint64(group) {
+		return CardGroup{}, fmt.Errorf("P41 response is for group %d, want %d", got, group)
+	}
+	if count != len(cards) || count > rfidCardsPerCommand {
+		return CardGroup{}, fmt.Errorf("P41 reports %d cards, got %d", count, len(cards))
+	}
+	return CardGroup{Group: group, Cards: cards}, nil
+}
+
+// parseCardResponse returns operation type or group, count and cards of P41 response
+func parseCardResponse(r Response) (uint64, int, []uint32, error) {
+	if err := r.expect("P41", 2); err != nil {
+		return 0, 0, nil, err
+	}
+	first, err := r.uint(0, 8)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	count, err := r.uint(1, 16)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	cards, err := parseCards(r, 2)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return first, int(count), cards, nil
+}
+
+// CardsFromGroups collects cards reported by P41 group query replies into a CardSet
+func CardsFromGroups(groups ...CardGroup) CardSet {
+	s := NewCardSet()
+	for _, g := range groups {
+		s.Add(g.Cards...)
+	}
+	return s
+}
+
+// CardRegistration is a parsed P42 response
+type CardRegistration struct {
+	Enabled    bool     // On-site registration state, valid when Registered is empty
+	Registered []uint32 // Cards registered on site and reported by the device
+}
+
+// ParseCardRegistration parses P42 response, either the function state or newly registered cards
+func ParseCardRegistration(r Response) (CardRegistration, error) {
+	if err := r.expect("P42", 1); err != nil {
+		return CardRegistration{}, err
+	}
+	if len(r.Params) == 1 {
+		// (8130630001,P42,1)
+		enabled, err := r.bool(0)
+		if err != nil {
+			return CardRegistration{}, err
+		}
+		return CardRegistration{Enabled: enabled}, nil
+	}
+
+	// (8130630001,P42,2,0008932328,0008933493)
+	count, err := r.uint(0, 8)
+	if err != nil {
+		return CardRegistration{}, err
+	}
+	cards, err := parseCards(r, 1)
+	if err != nil {
+		return CardRegistration{}, err
+	}
+	if int(count) != len(cards) {
+		return CardRegistration{}, fmt.Errorf("P42 reports %d cards, got %d", count, len(cards))
+	}
+	return CardRegistration{Enabled: true, Registered: cards}, nil
+}
+
+func parseCards(r Response, from int) ([]uint32, error) {
+	cards := make([]uint32, 0, len(r.Params)-from)
+	for n := from; n < len(r.Params); n++ {
+		c, err := r.uint(n, 32)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, uint32(c))
+	}
+	return cards, nil
+}
+
+func formatCard(c uint32) string {
+	return fmt.Sprintf("%010d", c)
+}
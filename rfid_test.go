@@ -0,0 +1,125 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCards(t *testing.T) {
+	cards := make([]uint32, 0, 23)
+	for c := uint32(1); c <= 23; c++ {
+		cards = append(cards, c)
+	}
+	cmds, err := AddCards(cards...)
+	assert.NoError(t, err)
+	assert.Len(t, cmds, 2)
+	assert.Len(t, cmds[0].Params, 3+20)
+	assert.Equal(t, "(P41,1,1,3,0000000021,0000000022,0000000023)", cmds[1].String())
+
+	_, err = AddCards()
+	assert.Error(t, err)
+	_, err = DeleteCards(0)
+	assert.Error(t, err)
+}
+
+func TestCardQueries(t *testing.T) {
+	cmd, err := QueryCards(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "(P41,0,1)", cmd.String())
+	_, err = QueryCards(26)
+	assert.Error(t, err)
+	assert.Len(t, QueryAllCards(), 25)
+	assert.Equal(t, "(P41,1,3)", DeleteAllCards().String())
+	assert.Equal(t, "(P42,1)", SetCardRegistration(true).String())
+}
+
+func TestSyncCards(t *testing.T) {
+	cmds, err := SyncCards(NewCardSet(2124750, 2153582, 15451297), NewCardSet(2153582, 8104563))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"(P41,1,2,2,0002124750,0015451297)", "(P41,1,1,1,0008104563)"}, []string{cmds[0].String(), cmds[1].String()})
+
+	cmds, err = SyncCards(NewCardSet(1, 2), NewCardSet())
+	assert.NoError(t, err)
+	assert.Equal(t, []Command{DeleteAllCards()}, cmds)
+
+	cmds, err = SyncCards(NewCardSet(1, 2), NewCardSet(2, 1))
+	assert.NoError(t, err)
+	assert.Empty(t, cmds)
+
+	tooMany := NewCardSet()
+	for c := uint32(1); c <= 501; c++ {
+		tooMany.Add(c)
+	}
+	_, err = SyncCards(NewCardSet(), tooMany)
+	assert.Error(t, err)
+}
+
+func TestParseCardReply(t *testing.T) {
+	r, _ := ParseResponse([]byte("(8130630001,P41,1,3,0006734739,0006688921,0007742247)"))
+	reply, err := ParseCardReply(r, CardAdd)
+	assert.NoError(t, err)
+	assert.Equal(t, CardReply{Operation: CardAdd, Count: 3, Cards: []uint32{6734739, 6688921, 7742247}}, reply)
+
+	r, _ = ParseResponse([]byte("(8130630001,P41,2,17)"))
+	reply, err = ParseCardReply(r, CardDelete)
+	assert.NoError(t, err)
+	assert.Equal(t, CardDelete, reply.Operation)
+	assert.Equal(t, 17, reply.Count)
+	assert.Empty(t, reply.Cards)
+
+	r, _ = ParseResponse([]byte("(8130630001,P41,3,0)"))
+	reply, err = ParseCardReply(r, CardDeleteAll)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reply.Count)
+
+	for _, tc := range []struct {
+		packet string
+		op     uint8
+	}{
+		{"(8130630001,P41,3,0)", CardDelete},
+		{"(8130630001,P41,1,3,0006734739)", CardAdd},
+		{"(8130630001,P41,2,1,0000000040)", CardDelete},
+		{"(8130630001,P41,1,1,card)", CardAdd},
+		{"(8130630001,P41,4,0)", 4},
+	} {
+		r, _ = ParseResponse([]byte(tc.packet))
+		_, err = ParseCardReply(r, tc.op)
+		assert.Error(t, err, tc.packet)
+	}
+}
+
+func TestParseCardGroup(t *testing.T) {
+	r, _ := ParseResponse([]byte("(8130630001,P41,1,3,0006734739,0006688921,0007742247)"))
+	first, err := ParseCardGroup(r, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, CardGroup{Group: 1, Cards: []uint32{6734739, 6688921, 7742247}}, first)
+
+	// a delete reply is not mistaken for the second group
+	r, _ = ParseResponse([]byte("(8130630001,P41,2,1,0000000040)"))
+	second, err := ParseCardGroup(r, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{40, 6688921, 6734739, 7742247}, CardsFromGroups(first, second).Sorted())
+	_, err = ParseCardGroup(r, 1)
+	assert.Error(t, err)
+	r, _ = ParseResponse([]byte("(8130630001,P41,2,17)"))
+	_, err = ParseCardGroup(r, 2)
+	assert.Error(t, err)
+}
+
+func TestParseCardRegistration(t *testing.T) {
+	r, _ := ParseResponse([]byte("(8130630001,P42,1)"))
+	reg, err := ParseCardRegistration(r)
+	assert.NoError(t, err)
+	assert.True(t, reg.Enabled)
+	assert.Empty(t, reg.Registered)
+
+	r, _ = ParseResponse([]byte("(8130630001,P42,2,0008932328,0008933493)"))
+	reg, err = ParseCardRegistration(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{8932328, 8933493}, reg.Registered)
+
+	r, _ = ParseResponse([]byte("(8130630001,P42,3,0008932328)"))
+	_, err = ParseCardRegistration(r)
+	assert.Error(t, err)
+}
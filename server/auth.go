@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+var (
+	// ErrUnknownTerminal is returned by Authorizer for terminals which are not allowed to connect
+	ErrUnknownTerminal = errors.New("unknown terminal")
+	// ErrIMEIMismatch is returned when the terminal reports another IMEI than registered, the device is cloned or
+	// misconfigured
+	ErrIMEIMismatch = errors.New("IMEI mismatch")
+	// ErrTerminalChanged is returned when packets of another terminal arrive on an authorized connection
+	ErrTerminalChanged = errors.New("terminal ID changed")
+)
+
+// Authorizer validates terminals connecting to the server. imei is empty when the first packet does not carry it,
+// e.g. heartbeat or ASCII response, the terminal is authorized again once its IMEI arrives with position data
+type Authorizer interface {
+	Authorize(ctx context.Context, terminalID, imei string) error
+}
+
+// AuthorizerFunc is an adapter allowing use of ordinary functions as Authorizer
+type AuthorizerFunc func(ctx context.Context, terminalID, imei string) error
+
+// Authorize calls f(ctx, terminalID, imei)
+func (f AuthorizerFunc) Authorize(ctx context.Context, terminalID, imei string) error {
+	return f(ctx, terminalID, imei)
+}
+
+// AllowList authorizes terminals listed with their IMEI, a terminal listed without IMEI may report any
+type AllowList struct {
+	mu      sync.RWMutex
+	entries map[string]string
+}
+
+// LoadAllowList reads allow list from file, see ParseAllowList for its format
+func LoadAllowList(path string) (*AllowList, error) {
+	a := &AllowList{}
+	if err := a.Load(path); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load replaces entries with the ones read from file, e.g. to reload it after a change
+func (a *AllowList) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := ParseAllowList(f)
+	if err != nil {
+		return fmt.Errorf("allow list %s, %w", path, err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = entries
+	return nil
+}
+
+// ParseAllowList reads lines with TerminalID optionally followed by IMEI separated by white space, empty lines and
+// lines starting with # are skipped
+//
+//	# TerminalID IMEI
+//	8000620011 868822040248195
+//	8130630001
+func ParseAllowList(r io.Reader) (map[string]string, error) {
+	entries := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d, want TerminalID and optional IMEI, got %q", line, text)
+		}
+		if _, ok := entries[fields[0]]; ok {
+			return nil, fmt.Errorf("line %d, duplicate terminal %s", line, fields[0])
+		}
+		entries[fields[0]] = ""
+		if len(fields) == 2 {
+			entries[fields[0]] = fields[1]
+		}
+	}
+	return entries, sc.Err()
+}
+
+// Set allows terminal with given IMEI, empty IMEI allows any
+func (a *AllowList) Set(terminalID, imei string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.entries == nil {
+		a.entries = make(map[string]string)
+	}
+	a.entries[terminalID] = imei
+}
+
+// Remove disallows terminal
+func (a *AllowList) Remove(terminalID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.entries, terminalID)
+}
+
+// Authorize implements Authorizer
+func (a *AllowList) Authorize(ctx context.Context, terminalID, imei string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	want, ok := a.entries[terminalID]
+	if !ok {
+		return fmt.Errorf("terminal %s, %w", terminalID, ErrUnknownTerminal)
+	}
+	if imei != "" && want != "" && imei != want {
+		return fmt.Errorf("terminal %s reported IMEI %s, registered %s, %w", terminalID, imei, want, ErrIMEIMismatch)
+	}
+	return nil
+}
+
+// Rejection is a connection closed because its packet was not authorized
+type Rejection struct {
+	Time       time.Time
+	RemoteAddr string
+	TerminalID string
+	IMEI       string
+	Err        error
+}
+
+// authorize checks decoded packet of the connection, it is called from the serving goroutine only
+func (c *Conn) authorize(d jointechparser.Decoded) error {
+	a := c.srv.Authorizer
+	id := TerminalID(d)
+	if a == nil || id == "" {
+		return nil
+	}
+	switch {
+	case c.terminalID == "":
+		if err := a.Authorize(c.ctx, id, d.IMEI); err != nil {
+			return err
+		}
+		c.terminalID, c.imei = id, d.IMEI
+	case id != c.terminalID:
+		return fmt.Errorf("terminal %s on connection of %s, %w", id, c.terminalID, ErrTerminalChanged)
+	case d.IMEI == "" || d.IMEI == c.imei:
+	case c.imei == "":
+		if err := a.Authorize(c.ctx, id, d.IMEI); err != nil {
+			return err
+		}
+		c.imei = d.IMEI
+	default:
+		return fmt.Errorf("terminal %s reported IMEI %s, connected with %s, %w", id, d.IMEI, c.imei, ErrIMEIMismatch)
+	}
+	return nil
+}
+
+// reject closes the connection and records the rejection
+func (c *Conn) reject(d jointechparser.Decoded, err error) {
+	s := c.srv
+	s.metrics.rejected.Add(1)
+	if errors.Is(err, ErrIMEIMismatch) {
+		s.metrics.imeiMismatches.Add(1)
+	}
+	s.logf("server: %s rejected, %v", c.RemoteAddr(), err)
+	if s.OnReject != nil {
+		s.OnReject(Rejection{
+			Time:       time.Now(),
+			RemoteAddr: c.RemoteAddr().String(),
+			TerminalID: TerminalID(d),
+			IMEI:       d.IMEI,
+			Err:        err,
+		})
+	}
+}
+
+// Rejected returns number of connections closed because their packets were not authorized
+func (s *Server) Rejected() uint64 {
+	return s.metrics.rejected.Load()
+}
+
+// IMEIMismatches returns number of rejected connections whose terminal reported another IMEI
+func (s *Server) IMEIMismatches() uint64 {
+	return s.metrics.imeiMismatches.Load()
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAllowList(t *testing.T) {
+	entries, err := ParseAllowList(strings.NewReader("# TerminalID IMEI\n\n8000620011 868822040248195\n  8130630001  \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"8000620011": "868822040248195", "8130630001": ""}, entries)
+
+	_, err = ParseAllowList(strings.NewReader("8000620011 868822040248195 x"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = ParseAllowList(strings.NewReader("8000620011\n8000620011 868822040248195"))
+	assert.ErrorContains(t, err, "line 2, duplicate terminal 8000620011")
+}
+
+func TestAllowList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allow.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("8000620011 868822040248195\n8130630001\n"), 0o644))
+	a, err := LoadAllowList(path)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, a.Authorize(ctx, "8000620011", ""))
+	assert.NoError(t, a.Authorize(ctx, "8000620011", "868822040248195"))
+	assert.ErrorIs(t, a.Authorize(ctx, "8000620011", "868822040248196"), ErrIMEIMismatch)
+	assert.NoError(t, a.Authorize(ctx, "8130630001", "868822040248196"))
+	assert.ErrorIs(t, a.Authorize(ctx, "8100620011", ""), ErrUnknownTerminal)
+
+	a.Set("8100620011", "")
+	assert.NoError(t, a.Authorize(ctx, "8100620011", ""))
+	a.Remove("8130630001")
+	assert.ErrorIs(t, a.Authorize(ctx, "8130630001", ""), ErrUnknownTerminal)
+
+	assert.NoError(t, os.WriteFile(path, []byte("8130630001\n"), 0o644))
+	assert.NoError(t, a.Load(path))
+	assert.ErrorIs(t, a.Authorize(ctx, "8000620011", ""), ErrUnknownTerminal)
+	_, err = LoadAllowList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestServerAuthorizer(t *testing.T) {
+	a := &AllowList{}
+	a.Set("8000620011", "868822040248195")
+	a.Set("8130630001", "")
+	var mu sync.Mutex
+	var rejections []Rejection
+	rec := newRecorder()
+	s := &Server{Handler: rec, Authorizer: a, OnReject: func(r Rejection) {
+		mu.Lock()
+		rejections = append(rejections, r)
+		mu.Unlock()
+	}}
+	addr := start(t, s)
+
+	// authorized by TerminalID first and by IMEI once position data arrives
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	c.Write(position(t))
+	rec.wait(t, 2)
+
+	unknown := dial(t, addr)
+	unknown.Write([]byte("(8100620011,@JT)"))
+	assert.True(t, closed(unknown))
+
+	// cloned device reports registered TerminalID with another IMEI
+	clone := dial(t, addr)
+	pos := position(t)
+	pos[55] = 0x6F
+	clone.Write(pos)
+	assert.True(t, closed(clone))
+
+	// packets of another terminal are not accepted on an authorized connection
+	c.Write([]byte("(8130630001,@JT)"))
+	assert.True(t, closed(c))
+
+	assert.Equal(t, uint64(3), s.Rejected())
+	assert.Equal(t, uint64(1), s.IMEIMismatches())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, rejections, 3)
+	assert.ErrorIs(t, rejections[0].Err, ErrUnknownTerminal)
+	assert.Equal(t, "8100620011", rejections[0].TerminalID)
+	assert.Equal(t, unknown.LocalAddr().String(), rejections[0].RemoteAddr)
+	assert.ErrorIs(t, rejections[1].Err, ErrIMEIMismatch)
+	assert.Equal(t, "868822040248196", rejections[1].IMEI)
+	assert.ErrorIs(t, rejections[2].Err, ErrTerminalChanged)
+}
+
+func TestServerAuthorizerFunc(t *testing.T) {
+	unavailable := errors.New("registry unavailable")
+	s := &Server{Handler: newRecorder(), Authorizer: AuthorizerFunc(func(ctx context.Context, terminalID, imei string) error {
+		return unavailable
+	})}
+	addr := start(t, s)
+	c := dial(t, addr)
+	c.Write([]byte(lockReport))
+	assert.True(t, closed(c))
+	assert.Equal(t, uint64(1), s.Rejected())
+	assert.Equal(t, uint64(0), s.IMEIMismatches())
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// ReplyKind is a type of automatic platform reply
+type ReplyKind int
+
+const (
+	ReplyAck             ReplyKind = iota + 1 // P69,0,serial acknowledging AckRequired position or alarm data, P45 reports and WLNET,5 data
+	ReplyTimeSync                             // P22 answering P22,2 time synchronization request
+	ReplyDynamicPassword                      // P52,2 echo of dynamic password report
+)
+
+func (k ReplyKind) String() string {
+	switch k {
+	case ReplyAck:
+		return "Ack"
+	case ReplyTimeSync:
+		return "TimeSync"
+	case ReplyDynamicPassword:
+		return "DynamicPassword"
+	}
+	return fmt.Sprintf("<unknown reply: %d>", int(k))
+}
+
+// AuditRecord is a single automatic reply sent to the device
+type AuditRecord struct {
+	Time       time.Time
+	TerminalID string
+	RemoteAddr string
+	Kind       ReplyKind
+	Command    jointechparser.Command
+	Err        error // Write error, nil when the reply was sent
+}
+
+// Auditor records automatic replies
+type Auditor interface {
+	Record(r AuditRecord)
+}
+
+// AuditLog keeps automatic replies in memory
+type AuditLog struct {
+	Max int // Maximum number of kept records, the oldest are dropped first, 0 means no limit
+
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+// Record implements Auditor
+func (l *AuditLog) Record(r AuditRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, r)
+	if l.Max > 0 && len(l.records) > l.Max {
+		l.records = append(l.records[:0], l.records[len(l.records)-l.Max:]...)
+	}
+}
+
+// Records returns copy of kept records
+func (l *AuditLog) Records() []AuditRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditRecord(nil), l.records...)
+}
+
+// AutoReply answers device messages which need a platform reply within seconds
+type AutoReply struct {
+	Ack             bool    // Acknowledge AckRequired position and alarm data, P45 reports and WLNET,5 peripheral data by P69
+	TimeSync        bool    // Answer P22,2 time synchronization requests by P22 with current UTC time
+	DynamicPassword bool    // Echo P52,2 dynamic password reports
+	Auditor         Auditor // Records every sent reply, optional
+	Now             func() time.Time
+}
+
+// NewAutoReply returns AutoReply with all replies enabled
+func NewAutoReply(auditor Auditor) *AutoReply {
+	return &AutoReply{Ack: true, TimeSync: true, DynamicPassword: true, Auditor: auditor}
+}
+
+// Wrap returns handler sending replies before passing packets to next
+func (a *AutoReply) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		for _, r := range a.Replies(d) {
+			a.send(ctx, c, r)
+		}
+		next.Handle(ctx, c, d)
+	})
+}
+
+// Reply is a reply to be sent to the device
+type Reply struct {
+	TerminalID string
+	Kind       ReplyKind
+	Command    jointechparser.Command
+}
+
+// Replies returns enabled replies decoded packet needs
+func (a *AutoReply) Replies(d jointechparser.Decoded) []Reply {
+	var rs []Reply
+	if a.Ack {
+		for _, p := range d.Data {
+			if p.LowEvents != nil && p.HasLowEvent(jointechparser.AckRequired) {
+				rs = append(rs, Reply{d.TerminalID, ReplyAck, jointechparser.Ack(uint32(p.SerialNo))})
+			}
+		}
+		for _, lr := range d.LockReports {
+			rs = append(rs, Reply{lr.TerminalID, ReplyAck, jointechparser.Ack(lr.SerialNo)})
+		}
+		for _, p := range d.Peripherals {
+			if !p.HasSerial {
+				continue
+			}
+			rs = append(rs, Reply{p.TerminalID, ReplyAck, jointechparser.Ack(uint32(p.SerialNo))})
+		}
+	}
+	for _, r := range d.Responses {
+		if a.TimeSync && jointechparser.IsTimeSyncRequest(r) {
+			rs = append(rs, Reply{r.TerminalID, ReplyTimeSync, jointechparser.TimeSync(a.now())})
+		}
+		if password, ok := jointechparser.ParseDynamicPasswordReport(r); ok && a.DynamicPassword {
+			rs = append(rs, Reply{r.TerminalID, ReplyDynamicPassword, jointechparser.ConfirmDynamicPassword(password)})
+		}
+	}
+	return rs
+}
+
+func (a *AutoReply) send(ctx context.Context, c *Conn, r Reply) {
+	err := c.WriteContext(ctx, r.Command.Bytes())
+	if a.Auditor == nil {
+		return
+	}
+	a.Auditor.Record(AuditRecord{
+		Time:       a.now(),
+		TerminalID: r.TerminalID,
+		RemoteAddr: c.RemoteAddr().String(),
+		Kind:       r.Kind,
+		Command:    r.Command,
+		Err:        err,
+	})
+}
+
+func (a *AutoReply) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoReply(t *testing.T) {
+	audit := &AuditLog{}
+	auto := NewAutoReply(audit)
+	auto.Now = func() time.Time { return time.Date(2020, 7, 15, 16, 43, 28, 0, time.UTC) }
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: auto.Wrap(rec)})
+	c := dial(t, addr)
+	r := bufio.NewReader(c)
+	peripheral, _ := hex.DecodeString(peripheralHex)
+
+	for _, tc := range []struct {
+		packet []byte
+		reply  string
+	}{
+		{position(t), "(P69,0,86)"},
+		{[]byte(lockReport), "(P69,0,24)"},
+		{[]byte("(8000620011,P22,2)"), "(P22,150720164328)"},
+		{[]byte("(8000620011,P52,2,113271)"), "(P52,2,113271)"},
+		{peripheral, "(P69,0,18)"},
+	} {
+		_, err := c.Write(tc.packet)
+		assert.NoError(t, err)
+		line, err := r.ReadString(')')
+		assert.NoError(t, err)
+		assert.Equal(t, tc.reply, line)
+	}
+	rec.wait(t, 5)
+
+	records := audit.Records()
+	assert.Len(t, records, 5)
+	assert.Equal(t, []ReplyKind{ReplyAck, ReplyAck, ReplyTimeSync, ReplyDynamicPassword},
+		[]ReplyKind{records[0].Kind, records[1].Kind, records[2].Kind, records[3].Kind})
+	assert.Equal(t, "8000620011", records[0].TerminalID)
+	assert.Equal(t, c.LocalAddr().String(), records[0].RemoteAddr)
+	assert.NoError(t, records[0].Err)
+	assert.Equal(t, "DynamicPassword", records[3].Kind.String())
+	assert.Equal(t, ReplyAck, records[4].Kind)
+	assert.Equal(t, "8130630001", records[4].TerminalID)
+}
+
+func TestAutoReplyConfig(t *testing.T) {
+	auto := &AutoReply{TimeSync: true}
+	p22, _ := jointechparser.ParseResponse([]byte("(8000620011,P22,2)"))
+	p52, _ := jointechparser.ParseResponse([]byte("(8000620011,P52,2,113271)"))
+	p22ok, _ := jointechparser.ParseResponse([]byte("(8000620011,P22,1)"))
+	lr, _ := jointechparser.ParseLockReport(mustResponse(t, lockReport))
+
+	rs := auto.Replies(jointechparser.Decoded{
+		Responses:   []jointechparser.Response{p22, p52, p22ok},
+		LockReports: []jointechparser.LockReport{lr},
+	})
+	assert.Len(t, rs, 1)
+	assert.Equal(t, ReplyTimeSync, rs[0].Kind)
+	assert.Equal(t, "8000620011", rs[0].TerminalID)
+
+	auto = &AutoReply{Ack: true}
+	rs = auto.Replies(jointechparser.Decoded{LockReports: []jointechparser.LockReport{lr}})
+	assert.Equal(t, []Reply{{TerminalID: "8000620011", Kind: ReplyAck, Command: jointechparser.Ack(24)}}, rs)
+
+	// WLNET,5 payload too short to carry the data serial number is not acknowledged
+	short, err := jointechparser.ParsePeripheralData([]byte("(8130630001,1,110,WLNET,5,2,\x19\x04)"))
+	assert.NoError(t, err)
+	assert.Empty(t, auto.Replies(jointechparser.Decoded{Peripherals: []jointechparser.PeripheralData{short}}))
+}
+
+func TestAuditLogMax(t *testing.T) {
+	audit := &AuditLog{Max: 2}
+	for _, k := range []ReplyKind{ReplyAck, ReplyTimeSync, ReplyDynamicPassword} {
+		audit.Record(AuditRecord{Kind: k})
+	}
+	records := audit.Records()
+	assert.Len(t, records, 2)
+	assert.Equal(t, ReplyTimeSync, records[0].Kind)
+}
+
+func TestAutoReplyAuditsWriteError(t *testing.T) {
+	audit := &AuditLog{}
+	rec := newRecorder()
+	next := NewAutoReply(audit).Wrap(rec)
+	addr := start(t, &Server{Handler: HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		// the device disconnects before the reply is written
+		c.Close()
+		next.Handle(ctx, c, d)
+	})})
+	c := dial(t, addr)
+	c.Write([]byte(lockReport))
+	rec.wait(t, 1)
+
+	records := audit.Records()
+	assert.Len(t, records, 1)
+	assert.Error(t, records[0].Err)
+}
+
+func mustResponse(t *testing.T, s string) jointechparser.Response {
+	r, err := jointechparser.ParseResponse([]byte(s))
+	assert.NoError(t, err)
+	return r
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// PositionKey identifies position record, a retransmitted or replayed record has the same key
+type PositionKey struct {
+	TerminalID string
+	SerialNo   uint8
+	Date       string // Record date in DDMMYY format, Utime holds only the time of day
+	Time       string // Record time in hhmmss format
+	Lat        float64
+	Lng        float64
+}
+
+// positionKey returns key of position record of the device
+func positionKey(terminalID string, p jointechparser.PALData) PositionKey {
+	return PositionKey{TerminalID: terminalID, SerialNo: p.SerialNo, Date: p.Date, Time: p.Time, Lat: p.Lat, Lng: p.Lng}
+}
+
+// DedupStore keeps keys of seen position records
+type DedupStore interface {
+	// Add records key seen at given time and reports whether it was recorded before
+	Add(key PositionKey, seen time.Time) (bool, error)
+	// Expire forgets keys last seen before given time
+	Expire(before time.Time) error
+}
+
+// MemoryDedupStore keeps keys in memory, they are lost on restart
+type MemoryDedupStore struct {
+	mu   sync.Mutex
+	keys map[PositionKey]time.Time
+}
+
+// Add implements DedupStore
+func (s *MemoryDedupStore) Add(key PositionKey, seen time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[PositionKey]time.Time)
+	}
+	_, dup := s.keys[key]
+	s.keys[key] = seen
+	return dup, nil
+}
+
+// Expire implements DedupStore
+func (s *MemoryDedupStore) Expire(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, seen := range s.keys {
+		if seen.Before(before) {
+			delete(s.keys, key)
+		}
+	}
+	return nil
+}
+
+// Len returns number of kept keys
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+// dedupEntry is a line of FileDedupStore file
+type dedupEntry struct {
+	Key  PositionKey
+	Seen time.Time
+}
+
+// FileDedupStore keeps keys in memory and appends them to a file as JSON lines, the file is rewritten with the
+// kept keys on Expire
+type FileDedupStore struct {
+	path string
+
+	mu  sync.Mutex
+	mem MemoryDedupStore
+	f   *os.File
+}
+
+// OpenFileDedupStore loads keys from file creating it when missing
+func OpenFileDedupStore(path string) (*FileDedupStore, error) {
+	s := &FileDedupStore{path: path}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		var e dedupEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("dedup store %s line %d, %w", path, line, err)
+		}
+		s.mem.Add(e.Key, e.Seen)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+// Add implements DedupStore, every sighting is appended so that Expire keeps keys seen again
+func (s *FileDedupStore) Add(key PositionKey, seen time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dup, _ := s.mem.Add(key, seen)
+	bs, err := json.Marshal(dedupEntry{key, seen})
+	if err != nil {
+		return dup, err
+	}
+	_, err = s.f.Write(append(bs, '\n'))
+	return dup, err
+}
+
+// Expire implements DedupStore
+func (s *FileDedupStore) Expire(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.Expire(before)
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for key, seen := range s.mem.keys {
+		if err = enc.Encode(dedupEntry{key, seen}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	return nil
+}
+
+// Close closes the file
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Dedup drops position records devices send again, i.e. records retransmitted because their acknowledgement was
+// lost and replayed blind-area data. It has to run after AutoReply so that retransmitted records are still
+// acknowledged and the device stops sending them
+type Dedup struct {
+	Store    DedupStore
+	Window   time.Duration // How long a record is remembered after it was last seen, defaults to 24 hours
+	ErrorLog *log.Logger   // Logs store errors, records are passed on when the store fails
+	Now      func() time.Time
+
+	mu         sync.Mutex
+	dropped    map[string]uint64
+	lastExpire time.Time
+}
+
+// NewDedup returns Dedup remembering records in store
+func NewDedup(store DedupStore) *Dedup {
+	return &Dedup{Store: store}
+}
+
+// Wrap returns handler passing packets without duplicate records to next, packets left without records are dropped
+func (d *Dedup) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, dec jointechparser.Decoded) {
+		dec, n := d.Filter(dec)
+		if n > 0 && len(dec.Data) == 0 {
+			return
+		}
+		next.Handle(ctx, c, dec)
+	})
+}
+
+// Filter returns packet without records seen before and the number of dropped records
+func (d *Dedup) Filter(dec jointechparser.Decoded) (jointechparser.Decoded, int) {
+	if len(dec.Data) == 0 {
+		return dec, 0
+	}
+	now := d.now()
+	d.expire(now)
+
+	data := make([]jointechparser.PALData, 0, len(dec.Data))
+	for _, p := range dec.Data {
+		dup, err := d.Store.Add(positionKey(dec.TerminalID, p), now)
+		if err != nil {
+			d.logf("dedup: terminal %s, %v", dec.TerminalID, err)
+		}
+		if !dup {
+			data = append(data, p)
+		}
+	}
+	n := len(dec.Data) - len(data)
+	if n > 0 {
+		d.mu.Lock()
+		if d.dropped == nil {
+			d.dropped = make(map[string]uint64)
+		}
+		d.dropped[dec.TerminalID] += uint64(n)
+		d.mu.Unlock()
+	}
+	dec.Data = data
+	return dec, n
+}
+
+// Dropped returns number of duplicate records dropped per TerminalID
+func (d *Dedup) Dropped() map[string]uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dropped := make(map[string]uint64, len(d.dropped))
+	for id, n := range d.dropped {
+		dropped[id] = n
+	}
+	return dropped
+}
+
+// expire forgets records outside of the window, at most once per tenth of the window
+func (d *Dedup) expire(now time.Time) {
+	window := d.window()
+	d.mu.Lock()
+	due := now.Sub(d.lastExpire) >= window/10
+	if due {
+		d.lastExpire = now
+	}
+	d.mu.Unlock()
+	if !due {
+		return
+	}
+	if err := d.Store.Expire(now.Add(-window)); err != nil {
+		d.logf("dedup: %v", err)
+	}
+}
+
+func (d *Dedup) window() time.Duration {
+	if d.Window > 0 {
+		return d.Window
+	}
+	return 24 * time.Hour
+}
+
+func (d *Dedup) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+func (d *Dedup) logf(format string, v ...any) {
+	if d.ErrorLog != nil {
+		d.ErrorLog.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
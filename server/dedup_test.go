@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	store := &MemoryDedupStore{}
+	dd := NewDedup(store)
+	dd.Window = time.Hour
+	clk := newClock()
+	dd.Now = clk.Now
+
+	var got []jointechparser.Decoded
+	h := dd.Wrap(HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) { got = append(got, d) }))
+	handle := func(bs []byte) { h.Handle(context.Background(), nil, decode(t, bs)) }
+
+	handle(position(t))
+	handle(position(t))
+	// the same record of another device, a record with another serial number and blind-area replay
+	other := position(t)
+	other[1] = 0x81
+	next := position(t)
+	next[len(next)-1]++
+	handle(other)
+	handle(next)
+	handle(positionOfType(t, jointechparser.BlindAreaPosition))
+	// the serial number wraps around, the same time of another day is a new record
+	nextDay := position(t)
+	nextDay[10] = 0x19
+	handle(nextDay)
+	handle([]byte(heartbeat))
+
+	assert.Len(t, got, 5)
+	assert.Equal(t, "8100620011", got[1].TerminalID)
+	assert.Equal(t, "190421", got[3].Data[0].Date)
+	assert.True(t, got[4].ContainsHealthcheck)
+	assert.Equal(t, map[string]uint64{"8000620011": 2}, dd.Dropped())
+	assert.Equal(t, 4, store.Len())
+
+	// records are forgotten after the window
+	clk.Add(time.Hour + time.Second)
+	d, n := dd.Filter(decode(t, position(t)))
+	assert.Equal(t, 0, n)
+	assert.Len(t, d.Data, 1)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestFileDedupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.jsonl")
+	s, err := OpenFileDedupStore(path)
+	assert.NoError(t, err)
+	at := time.Date(2020, 7, 15, 16, 43, 28, 0, time.UTC)
+	next := position(t)
+	next[len(next)-1]++
+	k1 := positionKey("8000620011", decode(t, position(t)).Data[0])
+	k2 := positionKey("8000620011", decode(t, next).Data[0])
+	assert.Equal(t, PositionKey{TerminalID: "8000620011", SerialNo: 86, Date: "180421", Time: "162259", Lat: k1.Lat, Lng: k1.Lng}, k1)
+
+	dup, err := s.Add(k1, at)
+	assert.NoError(t, err)
+	assert.False(t, dup)
+	s.Add(k2, at.Add(time.Hour))
+	assert.NoError(t, s.Close())
+
+	// keys survive restart
+	s, err = OpenFileDedupStore(path)
+	assert.NoError(t, err)
+	dup, _ = s.Add(k1, at.Add(2*time.Hour))
+	assert.True(t, dup)
+
+	// expired keys are removed from the file
+	assert.NoError(t, s.Expire(at.Add(90*time.Minute)))
+	s.Add(PositionKey{TerminalID: "8130630001"}, at.Add(2*time.Hour))
+	assert.NoError(t, s.Close())
+	s, err = OpenFileDedupStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.mem.Len())
+	dup, _ = s.Add(k2, at.Add(2*time.Hour))
+	assert.False(t, dup)
+	s.Close()
+
+	assert.NoError(t, os.WriteFile(path, []byte("{}\nnot json\n"), 0o644))
+	_, err = OpenFileDedupStore(path)
+	assert.ErrorContains(t, err, "line 2")
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rate allows Events per period, they may come in a single burst. Zero Rate is unlimited
+type Rate struct {
+	Events int
+	Per    time.Duration
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d per %v", r.Events, r.Per)
+}
+
+func (r Rate) unlimited() bool {
+	return r.Events <= 0 || r.Per <= 0
+}
+
+// Limits protects the server from misbehaving devices, zero values are unlimited
+type Limits struct {
+	MaxConns             int           // Maximum number of concurrent connections
+	MaxConnsPerIP        int           // Maximum number of concurrent connections from a single IP address
+	ConnRatePerIP        Rate          // New connections from a single IP address
+	ConnRatePerTerminal  Rate          // New connections of a single terminal, counted on its first authorized packet
+	FrameRatePerIP       Rate          // Packets received from a single IP address
+	FrameRatePerTerminal Rate          // Packets received from a single terminal, counted once authorized
+	FrameTimeout         time.Duration // Time a started packet has to be completed in, protects from slow senders
+}
+
+// Metrics are counters of the server
+type Metrics struct {
+	ActiveConns                  int    // Currently open connections
+	AcceptedConns                uint64 // Connections accepted since start
+	Rejected                     uint64 // Connections closed by Authorizer
+	IMEIMismatches               uint64 // Connections closed by Authorizer because of IMEI mismatch
+	MaxConnsExceeded             uint64 // Connections refused by MaxConns
+	MaxConnsPerIPExceeded        uint64 // Connections refused by MaxConnsPerIP
+	ConnRatePerIPExceeded        uint64 // Connections refused by ConnRatePerIP
+	ConnRatePerTerminalExceeded  uint64 // Connections closed by ConnRatePerTerminal
+	FrameRatePerIPExceeded       uint64 // Connections closed by FrameRatePerIP
+	FrameRatePerTerminalExceeded uint64 // Connections closed by FrameRatePerTerminal
+	FrameTimeouts                uint64 // Connections closed by FrameTimeout
+}
+
+// metrics holds counters updated by connections
+type metrics struct {
+	accepted                     atomic.Uint64
+	rejected                     atomic.Uint64
+	imeiMismatches               atomic.Uint64
+	maxConnsExceeded             atomic.Uint64
+	maxConnsPerIPExceeded        atomic.Uint64
+	connRatePerIPExceeded        atomic.Uint64
+	connRatePerTerminalExceeded  atomic.Uint64
+	frameRatePerIPExceeded       atomic.Uint64
+	frameRatePerTerminalExceeded atomic.Uint64
+	frameTimeouts                atomic.Uint64
+}
+
+// Metrics returns current values of server counters
+func (s *Server) Metrics() Metrics {
+	s.mu.Lock()
+	active := len(s.conns)
+	s.mu.Unlock()
+	m := &s.metrics
+	return Metrics{
+		ActiveConns:                  active,
+		AcceptedConns:                m.accepted.Load(),
+		Rejected:                     m.rejected.Load(),
+		IMEIMismatches:               m.imeiMismatches.Load(),
+		MaxConnsExceeded:             m.maxConnsExceeded.Load(),
+		MaxConnsPerIPExceeded:        m.maxConnsPerIPExceeded.Load(),
+		ConnRatePerIPExceeded:        m.connRatePerIPExceeded.Load(),
+		ConnRatePerTerminalExceeded:  m.connRatePerTerminalExceeded.Load(),
+		FrameRatePerIPExceeded:       m.frameRatePerIPExceeded.Load(),
+		FrameRatePerTerminalExceeded: m.frameRatePerTerminalExceeded.Load(),
+		FrameTimeouts:                m.frameTimeouts.Load(),
+	}
+}
+
+// errLimit is returned for connections refused by Limits
+var errLimit = errors.New("limit exceeded")
+
+// admit checks connection limits of new connection, it has to be called with s.mu held
+func (s *Server) admit(c *Conn, now time.Time) error {
+	l := &s.Limits
+	if l.MaxConns > 0 && len(s.conns) >= l.MaxConns {
+		s.metrics.maxConnsExceeded.Add(1)
+		return fmt.Errorf("%d connections, %w", l.MaxConns, errLimit)
+	}
+	if l.MaxConnsPerIP > 0 && s.perIP[c.ip] >= l.MaxConnsPerIP {
+		s.metrics.maxConnsPerIPExceeded.Add(1)
+		return fmt.Errorf("%d connections from %s, %w", l.MaxConnsPerIP, c.ip, errLimit)
+	}
+	if !s.limiters().connsByIP.allow(c.ip, now) {
+		s.metrics.connRatePerIPExceeded.Add(1)
+		return fmt.Errorf("connection rate %v from %s, %w", l.ConnRatePerIP, c.ip, errLimit)
+	}
+	return nil
+}
+
+// limiters are rate limiters of the server
+type limiters struct {
+	connsByIP        *limiter
+	connsByTerminal  *limiter
+	framesByIP       *limiter
+	framesByTerminal *limiter
+}
+
+// limiters returns rate limiters of Limits, they are created on first use
+func (s *Server) limiters() *limiters {
+	s.limitersOnce.Do(func() {
+		l := &s.Limits
+		s.rateLimiters = &limiters{
+			connsByIP:        newLimiter(l.ConnRatePerIP),
+			connsByTerminal:  newLimiter(l.ConnRatePerTerminal),
+			framesByIP:       newLimiter(l.FrameRatePerIP),
+			framesByTerminal: newLimiter(l.FrameRatePerTerminal),
+		}
+	})
+	return s.rateLimiters
+}
+
+// limitIP checks frame rate of the connection IP address
+func (c *Conn) limitIP(now time.Time) error {
+	s := c.srv
+	if !s.limiters().framesByIP.allow(c.ip, now) {
+		s.metrics.frameRatePerIPExceeded.Add(1)
+		return fmt.Errorf("frame rate %v from %s, %w", s.Limits.FrameRatePerIP, c.ip, errLimit)
+	}
+	return nil
+}
+
+// limitTerminal checks connection rate of the terminal on its first packet and its frame rate
+func (c *Conn) limitTerminal(terminalID string, now time.Time) error {
+	s := c.srv
+	if c.limitedID == "" {
+		c.limitedID = terminalID
+		if !s.limiters().connsByTerminal.allow(terminalID, now) {
+			s.metrics.connRatePerTerminalExceeded.Add(1)
+			return fmt.Errorf("connection rate %v of terminal %s, %w", s.Limits.ConnRatePerTerminal, terminalID, errLimit)
+		}
+	}
+	if !s.limiters().framesByTerminal.allow(terminalID, now) {
+		s.metrics.frameRatePerTerminalExceeded.Add(1)
+		return fmt.Errorf("frame rate %v of terminal %s, %w", s.Limits.FrameRatePerTerminal, terminalID, errLimit)
+	}
+	return nil
+}
+
+// hostOf returns IP address of the connection
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// bucket is a token bucket of a single key
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket rate limiter keyed by IP address or TerminalID
+type limiter struct {
+	rate Rate
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(r Rate) *limiter {
+	return &limiter{rate: r, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token of key and reports whether it was available
+func (l *limiter) allow(key string, now time.Time) bool {
+	if l.rate.unlimited() {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	burst := float64(l.rate.Events)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(burst, b.tokens+burst*float64(now.Sub(b.last))/float64(l.rate.Per))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops refilled buckets once per period, they behave the same as missing ones
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.rate.Per {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.rate.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(Rate{Events: 2, Per: time.Second})
+	now := time.Date(2020, 7, 15, 16, 43, 28, 0, time.UTC)
+	assert.True(t, l.allow("127.0.0.1", now))
+	assert.True(t, l.allow("127.0.0.1", now))
+	assert.False(t, l.allow("127.0.0.1", now))
+	assert.True(t, l.allow("127.0.0.2", now))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, l.allow("127.0.0.1", now))
+	assert.False(t, l.allow("127.0.0.1", now))
+
+	// refilled buckets are dropped
+	now = now.Add(time.Second)
+	assert.True(t, l.allow("127.0.0.1", now))
+	assert.Len(t, l.buckets, 1)
+
+	assert.True(t, newLimiter(Rate{}).allow("127.0.0.1", now))
+	assert.Equal(t, "2 per 1s", l.rate.String())
+}
+
+func TestMaxConns(t *testing.T) {
+	rec := newRecorder()
+	s := &Server{Handler: rec, Limits: Limits{MaxConns: 1}}
+	addr := start(t, s)
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+
+	assert.True(t, closed(dial(t, addr)))
+	m := s.Metrics()
+	assert.Equal(t, 1, m.ActiveConns)
+	assert.Equal(t, uint64(1), m.AcceptedConns)
+	assert.Equal(t, uint64(1), m.MaxConnsExceeded)
+
+	c.Close()
+	assertEventually(t, func() bool { return s.Metrics().ActiveConns == 0 })
+	c = dial(t, addr)
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	rec := newRecorder()
+	s := &Server{Handler: rec, Limits: Limits{MaxConnsPerIP: 2}}
+	addr := start(t, s)
+	for i := 0; i < 2; i++ {
+		dial(t, addr).Write([]byte(heartbeat))
+	}
+	rec.wait(t, 2)
+	assert.True(t, closed(dial(t, addr)))
+	assert.Equal(t, uint64(1), s.Metrics().MaxConnsPerIPExceeded)
+}
+
+func TestConnRatePerIP(t *testing.T) {
+	rec := newRecorder()
+	s := &Server{Handler: rec, Limits: Limits{ConnRatePerIP: Rate{Events: 1, Per: time.Hour}}}
+	addr := start(t, s)
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+	c.Close()
+
+	assert.True(t, closed(dial(t, addr)))
+	assert.Equal(t, uint64(1), s.Metrics().ConnRatePerIPExceeded)
+}
+
+func TestConnRatePerTerminal(t *testing.T) {
+	rec := newRecorder()
+	s := &Server{Handler: rec, Limits: Limits{ConnRatePerTerminal: Rate{Events: 1, Per: time.Hour}}}
+	addr := start(t, s)
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 2)
+
+	// the terminal reconnects in a reboot loop, other terminals are not affected
+	again := dial(t, addr)
+	again.Write([]byte(heartbeat))
+	assert.True(t, closed(again))
+	dial(t, addr).Write([]byte("(8130630001,@JT)"))
+	decoded := rec.wait(t, 1)
+	assert.Equal(t, "8130630001", decoded[2].TerminalID)
+	assert.Equal(t, uint64(1), s.Metrics().ConnRatePerTerminalExceeded)
+}
+
+func TestTerminalLimitsAfterAuthorizer(t *testing.T) {
+	a := &AllowList{}
+	a.Set("8000620011", "868822040248195")
+	rec := newRecorder()
+	s := &Server{Handler: rec, Authorizer: a, Limits: Limits{
+		ConnRatePerTerminal:  Rate{Events: 1, Per: time.Hour},
+		FrameRatePerTerminal: Rate{Events: 2, Per: time.Hour},
+	}}
+	addr := start(t, s)
+
+	// spoofed device claims TerminalID of the registered one
+	clone := dial(t, addr)
+	pos := position(t)
+	pos[55] = 0x6F
+	clone.Write(pos)
+	assert.True(t, closed(clone))
+
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 2)
+	m := s.Metrics()
+	assert.Equal(t, uint64(1), m.Rejected)
+	assert.Equal(t, uint64(0), m.ConnRatePerTerminalExceeded)
+	assert.Equal(t, uint64(0), m.FrameRatePerTerminalExceeded)
+}
+
+func TestFrameRate(t *testing.T) {
+	rec := newRecorder()
+	s := &Server{Handler: rec, Limits: Limits{
+		FrameRatePerIP:       Rate{Events: 4, Per: time.Hour},
+		FrameRatePerTerminal: Rate{Events: 2, Per: time.Hour},
+	}}
+	addr := start(t, s)
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat + heartbeat + heartbeat))
+	assert.True(t, closed(c))
+	rec.wait(t, 2)
+
+	c = dial(t, addr)
+	c.Write([]byte("(8130630001,@JT)(8130630001,@JT)"))
+	assert.True(t, closed(c))
+	rec.wait(t, 1)
+
+	m := s.Metrics()
+	assert.Equal(t, uint64(1), m.FrameRatePerTerminalExceeded)
+	assert.Equal(t, uint64(1), m.FrameRatePerIPExceeded)
+}
+
+func TestFrameTimeout(t *testing.T) {
+	s := &Server{Handler: newRecorder(), Limits: Limits{FrameTimeout: 100 * time.Millisecond}}
+	addr := start(t, s)
+	idle := dial(t, addr)
+	slow := dial(t, addr)
+	slow.Write([]byte("(8000620011,"))
+	assert.True(t, closed(slow))
+	assert.Equal(t, uint64(1), s.Metrics().FrameTimeouts)
+
+	// connection without a started packet is kept until ReadTimeout
+	idle.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := idle.Read(make([]byte, 1))
+	var ne net.Error
+	assert.True(t, errors.As(err, &ne) && ne.Timeout())
+}
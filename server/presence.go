@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// PresenceState is a presence of the device derived from its reporting
+type PresenceState int
+
+const (
+	DeviceOnline  PresenceState = iota + 1 // Device reports as configured, including sleeping between RTC wake ups
+	DeviceSilent                           // Device missed uploads while it was expected to be awake
+	DeviceOffline                          // Device missed its RTC wake up
+)
+
+func (s PresenceState) String() string {
+	switch s {
+	case DeviceOnline:
+		return "Online"
+	case DeviceSilent:
+		return "Silent"
+	case DeviceOffline:
+		return "Offline"
+	}
+	return fmt.Sprintf("<unknown presence: %d>", int(s))
+}
+
+// PresenceEvent is emitted when the device changes its presence state
+type PresenceEvent struct {
+	TerminalID string
+	State      PresenceState
+	Time       time.Time
+	LastSeen   time.Time // Time of the last packet before the change
+}
+
+// Presence is a presence state of the device
+type Presence struct {
+	TerminalID    string
+	State         PresenceState
+	LastSeen      time.Time // Time of the last packet
+	LastHeartbeat time.Time
+	LastPosition  time.Time
+	Woke          time.Time // Time of the first packet after the last wake up
+	Config        jointechparser.DeviceConfig
+}
+
+// SilentAfter returns how long the device may stay quiet while awake
+func (p *Presence) SilentAfter(missed int) time.Duration {
+	return time.Duration(missed) * time.Duration(p.Config.UploadInterval) * time.Second
+}
+
+// OfflineAfter returns how long the device may stay quiet while asleep
+func (p *Presence) OfflineAfter(missed int) time.Duration {
+	return time.Duration(missed) * time.Duration(p.Config.RTCInterval) * time.Minute
+}
+
+// awakeUntil returns end of the current wake up period, zero time when the device tracks without sleeping
+func (p *Presence) awakeUntil() time.Time {
+	if p.Config.Tracking {
+		return time.Time{}
+	}
+	return p.Woke.Add(time.Duration(p.Config.WakeWorkTime) * time.Minute)
+}
+
+// PresenceTracker keeps presence of devices from their packets. Expected intervals come from upload interval,
+// RTC interval, wake up working time and tracking mode of the device config. Configs are taken from P04, P39 and
+// P54 responses passing the tracker, devices without them use Config. Check has to be called periodically, e.g.
+// by Run, to detect silent and offline devices
+type PresenceTracker struct {
+	Config  jointechparser.DeviceConfig // Timings of devices without own config
+	Missed  int                         // Number of missed uploads or wake ups before the state changes, defaults to 2
+	OnEvent func(PresenceEvent)         // Called on every state change, optional
+	Now     func() time.Time
+
+	mu      sync.Mutex
+	devices map[string]*Presence
+}
+
+// NewPresenceTracker returns tracker using factory default timings
+func NewPresenceTracker(onEvent func(PresenceEvent)) *PresenceTracker {
+	return &PresenceTracker{Config: jointechparser.DefaultDeviceConfig(), OnEvent: onEvent}
+}
+
+// Wrap returns handler updating presence before passing packets to next
+func (t *PresenceTracker) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		t.Seen(d)
+		next.Handle(ctx, c, d)
+	})
+}
+
+// SetConfig sets timings of the device, e.g. parsed by ParseDeviceConfig
+func (t *PresenceTracker) SetConfig(terminalID string, cfg jointechparser.DeviceConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.presence(terminalID).Config = cfg
+}
+
+// Seen updates presence with decoded packet, a device which was not online becomes online
+func (t *PresenceTracker) Seen(d jointechparser.Decoded) {
+	id := TerminalID(d)
+	if id == "" {
+		return
+	}
+	now := t.now()
+
+	t.mu.Lock()
+	p := t.presence(id)
+	for _, r := range d.Responses {
+		switch r.Word {
+		case "P04", "P39", "P54":
+			cfg := p.Config
+			if cfg.Apply(r) == nil {
+				p.Config = cfg
+			}
+		}
+	}
+	var ev *PresenceEvent
+	if p.State != DeviceOnline {
+		ev = &PresenceEvent{TerminalID: id, State: DeviceOnline, Time: now, LastSeen: p.LastSeen}
+		p.State = DeviceOnline
+	}
+	if ev != nil || now.Sub(p.LastSeen) >= p.SilentAfter(t.missed()) {
+		p.Woke = now
+	}
+	p.LastSeen = now
+	if d.ContainsHealthcheck {
+		p.LastHeartbeat = now
+	}
+	if len(d.Data) > 0 || len(d.LockReports) > 0 {
+		p.LastPosition = now
+	}
+	t.mu.Unlock()
+
+	if ev != nil {
+		t.emit(*ev)
+	}
+}
+
+// Check changes state of devices which stopped reporting and returns emitted events
+func (t *PresenceTracker) Check() []PresenceEvent {
+	now := t.now()
+	missed := t.missed()
+
+	t.mu.Lock()
+	var evs []PresenceEvent
+	for _, p := range t.devices {
+		if p.LastSeen.IsZero() {
+			continue
+		}
+		quiet := now.Sub(p.LastSeen)
+		state := p.State
+		switch {
+		case quiet >= p.OfflineAfter(missed):
+			state = DeviceOffline
+		case p.State == DeviceOnline && quiet >= p.SilentAfter(missed):
+			// uploads are expected only until the device goes back to sleep
+			if until := p.awakeUntil(); until.IsZero() || until.Sub(p.LastSeen) >= p.SilentAfter(missed) {
+				state = DeviceSilent
+			}
+		}
+		if state != p.State {
+			p.State = state
+			evs = append(evs, PresenceEvent{TerminalID: p.TerminalID, State: state, Time: now, LastSeen: p.LastSeen})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, ev := range evs {
+		t.emit(ev)
+	}
+	return evs
+}
+
+// Run calls Check every interval until ctx is done
+func (t *PresenceTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Check()
+		}
+	}
+}
+
+// Presence returns a copy of presence of the device
+func (t *PresenceTracker) Presence(terminalID string) (Presence, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.devices[terminalID]
+	if !ok {
+		return Presence{}, false
+	}
+	return *p, true
+}
+
+// presence returns presence of the device creating it, it has to be called with t.mu held
+func (t *PresenceTracker) presence(terminalID string) *Presence {
+	p, ok := t.devices[terminalID]
+	if !ok {
+		p = &Presence{TerminalID: terminalID, Config: t.Config}
+		if t.devices == nil {
+			t.devices = make(map[string]*Presence)
+		}
+		t.devices[terminalID] = p
+	}
+	return p
+}
+
+func (t *PresenceTracker) emit(ev PresenceEvent) {
+	if t.OnEvent != nil {
+		t.OnEvent(ev)
+	}
+}
+
+func (t *PresenceTracker) missed() int {
+	if t.Missed > 0 {
+		return t.Missed
+	}
+	return 2
+}
+
+func (t *PresenceTracker) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
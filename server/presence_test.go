@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence(t *testing.T) {
+	var evs []PresenceEvent
+	tr := NewPresenceTracker(func(ev PresenceEvent) { evs = append(evs, ev) })
+	clk := newClock()
+	tr.Now = clk.Now
+	start := clk.Now()
+	h := tr.Wrap(HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {}))
+	seen := func(packet []byte) {
+		h.Handle(context.Background(), nil, decode(t, packet))
+	}
+
+	seen([]byte(heartbeat))
+	assert.Equal(t, []PresenceEvent{{TerminalID: "8000620011", State: DeviceOnline, Time: start}}, evs)
+	clk.Add(time.Minute)
+	seen(position(t))
+	p, _ := tr.Presence("8000620011")
+	assert.Equal(t, start, p.LastHeartbeat)
+	assert.Equal(t, start.Add(time.Minute), p.LastPosition)
+	assert.Equal(t, start, p.Woke)
+
+	// two uploads missed in the middle of the wake up period
+	clk.Add(2*time.Minute - time.Second)
+	assert.Empty(t, tr.Check())
+	clk.Add(time.Second)
+	assert.Equal(t, []PresenceEvent{{TerminalID: "8000620011", State: DeviceSilent, Time: clk.Now(), LastSeen: start.Add(time.Minute)}}, tr.Check())
+	assert.Empty(t, tr.Check())
+
+	seen([]byte(heartbeat))
+	assert.Equal(t, DeviceOnline, evs[len(evs)-1].State)
+	woke := clk.Now()
+
+	// the device falls asleep at the end of wake up working time, it is online until it misses RTC wake ups
+	for n := 0; n < 9; n++ {
+		clk.Add(time.Minute)
+		seen(position(t))
+	}
+	clk.Add(30 * time.Minute)
+	assert.Empty(t, tr.Check())
+	p, _ = tr.Presence("8000620011")
+	assert.Equal(t, DeviceOnline, p.State)
+	assert.Equal(t, woke, p.Woke)
+	clk.Add(30 * time.Minute)
+	evs = tr.Check()
+	assert.Len(t, evs, 1)
+	assert.Equal(t, DeviceOffline, evs[0].State)
+	assert.Equal(t, "Offline", evs[0].State.String())
+
+	clk.Add(time.Hour)
+	seen([]byte(lockReport))
+	p, _ = tr.Presence("8000620011")
+	assert.Equal(t, DeviceOnline, p.State)
+	assert.Equal(t, clk.Now(), p.Woke)
+	assert.Equal(t, clk.Now(), p.LastPosition)
+}
+
+func TestPresenceConfig(t *testing.T) {
+	tr := NewPresenceTracker(nil)
+	clk := newClock()
+	tr.Now = clk.Now
+
+	// timings are taken from responses of the device
+	tr.Seen(decode(t, []byte("(8000620011,P04,10,5)")))
+	tr.Seen(decode(t, []byte("(8000620011,P54,1,1)")))
+	p, _ := tr.Presence("8000620011")
+	assert.Equal(t, uint16(10), p.Config.UploadInterval)
+	assert.Equal(t, uint16(5), p.Config.RTCInterval)
+	assert.True(t, p.Config.Tracking)
+
+	// tracking device never sleeps
+	clk.Add(20 * time.Second)
+	evs := tr.Check()
+	assert.Len(t, evs, 1)
+	assert.Equal(t, DeviceSilent, evs[0].State)
+	clk.Add(10 * time.Minute)
+	evs = tr.Check()
+	assert.Len(t, evs, 1)
+	assert.Equal(t, DeviceOffline, evs[0].State)
+
+	cfg := jointechparser.DefaultDeviceConfig()
+	cfg.RTCInterval = 60
+	tr.SetConfig("8130630001", cfg)
+	assert.Empty(t, tr.Check())
+	tr.Seen(decode(t, []byte("(8130630001,@JT)")))
+	clk.Add(time.Hour + 59*time.Minute)
+	evs = tr.Check()
+	assert.Len(t, evs, 1)
+	assert.Equal(t, DeviceSilent, evs[0].State)
+	clk.Add(time.Minute)
+	evs = tr.Check()
+	assert.Len(t, evs, 1)
+	assert.Equal(t, DeviceOffline, evs[0].State)
+}
+
+func TestPresenceRun(t *testing.T) {
+	got := make(chan PresenceEvent, 10)
+	tr := NewPresenceTracker(func(ev PresenceEvent) { got <- ev })
+	tr.Config.Tracking = true
+	tr.Config.UploadInterval = 0
+	tr.Seen(decode(t, []byte(heartbeat)))
+	assert.Equal(t, DeviceOnline, (<-got).State)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.Run(ctx, 10*time.Millisecond)
+	select {
+	case ev := <-got:
+		assert.Equal(t, DeviceSilent, ev.State)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event from Run")
+	}
+}
@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// CommandStatus is a delivery state of queued command
+type CommandStatus int
+
+const (
+	CommandQueued   CommandStatus = iota + 1 // Waiting for the device to connect
+	CommandSent                              // Written to the device, waiting for its response
+	CommandAnswered                          // Response received
+	CommandExpired                           // Not answered before its expiry
+	CommandFailed                            // Not answered after MaxAttempts writes
+	CommandCanceled                          // Removed from the queue by Cancel
+)
+
+func (s CommandStatus) String() string {
+	switch s {
+	case CommandQueued:
+		return "Queued"
+	case CommandSent:
+		return "Sent"
+	case CommandAnswered:
+		return "Answered"
+	case CommandExpired:
+		return "Expired"
+	case CommandFailed:
+		return "Failed"
+	case CommandCanceled:
+		return "Canceled"
+	}
+	return fmt.Sprintf("<unknown status: %d>", int(s))
+}
+
+// Done reports whether the command left the queue
+func (s CommandStatus) Done() bool {
+	return s != CommandQueued && s != CommandSent
+}
+
+// QueuedCommand is a command waiting for delivery to the device
+type QueuedCommand struct {
+	ID         uint64
+	TerminalID string
+	Command    jointechparser.Command
+	Queued     time.Time
+	Expires    time.Time // Zero when the command never expires
+	Status     CommandStatus
+	Attempts   int       // Number of writes to the device
+	Sent       time.Time // Time of the last write
+	Answered   time.Time
+	Response   *jointechparser.Response // Response of the device, nil for OTA-9 commands answered by OTAReply
+	LastError  string                   // Last write error
+}
+
+// QueueStore persists queued commands
+type QueueStore interface {
+	Load() ([]QueuedCommand, error)
+	Save(cmds []QueuedCommand) error
+}
+
+// MemoryQueueStore keeps commands in memory, they are lost on restart
+type MemoryQueueStore struct {
+	mu   sync.Mutex
+	cmds []QueuedCommand
+}
+
+// Load implements QueueStore
+func (s *MemoryQueueStore) Load() ([]QueuedCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]QueuedCommand(nil), s.cmds...), nil
+}
+
+// Save implements QueueStore
+func (s *MemoryQueueStore) Save(cmds []QueuedCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmds = append(s.cmds[:0], cmds...)
+	return nil
+}
+
+// FileQueueStore keeps commands in a JSON file which is replaced atomically on every change
+type FileQueueStore struct {
+	Path string
+}
+
+// Load implements QueueStore, missing file means empty queue
+func (s FileQueueStore) Load() ([]QueuedCommand, error) {
+	bs, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cmds []QueuedCommand
+	if err := json.Unmarshal(bs, &cmds); err != nil {
+		return nil, fmt.Errorf("command queue %s, %w", s.Path, err)
+	}
+	return cmds, nil
+}
+
+// Save implements QueueStore
+func (s FileQueueStore) Save(cmds []QueuedCommand) error {
+	bs, err := json.Marshal(cmds)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
+
+// CommandQueue delivers commands to devices which are mostly asleep. Commands are kept per TerminalID and written
+// in order as soon as the device reports, any packet proves the device is awake. A written command is answered by
+// the first response with its command word, commands not answered within ResponseTimeout are written again on
+// the next packet until MaxAttempts is reached
+type CommandQueue struct {
+	Store           QueueStore
+	TTL             time.Duration // Expiry of enqueued commands, 0 means they never expire
+	ResponseTimeout time.Duration // Defaults to 1 minute
+	MaxAttempts     int           // Defaults to 3
+	Registry        *Registry     // Optional, commands of connected devices are written immediately when enqueued
+	ErrorLog        *log.Logger   // Logs store and write errors, defaults to the standard logger
+	Now             func() time.Time
+
+	mu       sync.Mutex
+	cmds     []*QueuedCommand
+	nextID   uint64
+	flushing map[string]chan struct{} // Closed when running Flush of the TerminalID returns
+}
+
+// NewCommandQueue returns queue with commands loaded from store
+func NewCommandQueue(store QueueStore) (*CommandQueue, error) {
+	cmds, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	q := &CommandQueue{Store: store}
+	for n := range cmds {
+		q.cmds = append(q.cmds, &cmds[n])
+		q.nextID = max(q.nextID, cmds[n].ID)
+	}
+	return q, nil
+}
+
+// Enqueue adds command for the device and returns its ID
+func (q *CommandQueue) Enqueue(ctx context.Context, terminalID string, cmd jointechparser.Command) (uint64, error) {
+	now := q.now()
+	q.mu.Lock()
+	q.nextID++
+	qc := &QueuedCommand{ID: q.nextID, TerminalID: terminalID, Command: cmd, Queued: now, Status: CommandQueued}
+	if q.TTL > 0 {
+		qc.Expires = now.Add(q.TTL)
+	}
+	q.cmds = append(q.cmds, qc)
+	err := q.save()
+	q.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if q.Registry != nil {
+		if s, ok := q.Registry.Session(terminalID); ok {
+			if err := q.Flush(ctx, terminalID, s.Conn); err != nil {
+				q.logf("command queue: %v", err)
+			}
+		}
+	}
+	return qc.ID, nil
+}
+
+// Command returns a copy of command with given ID
+func (q *CommandQueue) Command(id uint64) (QueuedCommand, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, qc := range q.cmds {
+		if qc.ID == id {
+			return *qc, true
+		}
+	}
+	return QueuedCommand{}, false
+}
+
+// Commands returns copies of commands of the device in queue order including finished ones
+func (q *CommandQueue) Commands(terminalID string) []QueuedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var cmds []QueuedCommand
+	for _, qc := range q.cmds {
+		if qc.TerminalID == terminalID {
+			cmds = append(cmds, *qc)
+		}
+	}
+	return cmds
+}
+
+// Cancel removes command from the queue, a command already sent may still be executed by the device
+func (q *CommandQueue) Cancel(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, qc := range q.cmds {
+		if qc.ID != id {
+			continue
+		}
+		if qc.Status.Done() {
+			return fmt.Errorf("command %d is %s", id, qc.Status)
+		}
+		qc.Status = CommandCanceled
+		return q.save()
+	}
+	return fmt.Errorf("unknown command %d", id)
+}
+
+// Prune drops finished commands enqueued before given time and returns their number
+func (q *CommandQueue) Prune(before time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.cmds[:0]
+	for _, qc := range q.cmds {
+		if !qc.Status.Done() || !qc.Queued.Before(before) {
+			kept = append(kept, qc)
+		}
+	}
+	n := len(q.cmds) - len(kept)
+	clear(q.cmds[len(kept):])
+	q.cmds = kept
+	if n == 0 {
+		return 0, nil
+	}
+	return n, q.save()
+}
+
+// Wrap returns handler applying responses to sent commands and flushing the queue of the reporting device before
+// passing packets to next
+func (q *CommandQueue) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		if id := TerminalID(d); id != "" {
+			if err := q.Answer(d); err != nil {
+				q.logf("command queue: %v", err)
+			}
+			if err := q.Flush(ctx, id, c); err != nil {
+				q.logf("command queue: %v", err)
+			}
+		}
+		next.Handle(ctx, c, d)
+	})
+}
+
+// Answer marks the oldest sent commands matching responses of decoded packet as answered
+func (q *CommandQueue) Answer(d jointechparser.Decoded) error {
+	now := q.now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	changed := false
+	for _, r := range d.Responses {
+		if jointechparser.IsTimeSyncRequest(r) {
+			continue
+		}
+		if qc := q.sent(r.TerminalID, func(cmd jointechparser.Command) bool { return cmd.Word == r.Word }); qc != nil {
+			r := r
+			qc.Status, qc.Answered, qc.Response = CommandAnswered, now, &r
+			changed = true
+		}
+	}
+	for _, o := range d.OTAReplies {
+		if qc := q.sent(o.TerminalID, isOTACommand); qc != nil {
+			qc.Status, qc.Answered = CommandAnswered, now
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return q.save()
+}
+
+// sent returns the oldest sent command of the device matching fn
+func (q *CommandQueue) sent(terminalID string, fn func(cmd jointechparser.Command) bool) *QueuedCommand {
+	for _, qc := range q.cmds {
+		if qc.TerminalID == terminalID && qc.Status == CommandSent && fn(qc.Command) {
+			return qc
+		}
+	}
+	return nil
+}
+
+// isOTACommand reports whether command is OTA-9 command answered by OTAReply
+func isOTACommand(cmd jointechparser.Command) bool {
+	return len(cmd.Params) > 2 && cmd.Params[2] == "OTA"
+}
+
+// Flush writes due commands of the device to c in queue order. Expired commands and commands exceeding
+// MaxAttempts are finished first, writing stops on the first error. The queue is not locked while writing,
+// so a slow device does not block other connections, and it is saved only when a command changed. Flushes of
+// the same device run one at a time, so a command is not written twice by a packet and Enqueue racing
+func (q *CommandQueue) Flush(ctx context.Context, terminalID string, c *Conn) error {
+	done, err := q.startFlush(ctx, terminalID)
+	if err != nil {
+		return err
+	}
+	defer q.endFlush(terminalID, done)
+
+	now := q.now()
+	q.mu.Lock()
+	var changed bool
+	var due []*QueuedCommand
+	var cmds []jointechparser.Command
+	for _, qc := range q.cmds {
+		if qc.TerminalID != terminalID || qc.Status.Done() {
+			continue
+		}
+		if !qc.Expires.IsZero() && !now.Before(qc.Expires) {
+			qc.Status, changed = CommandExpired, true
+			continue
+		}
+		if qc.Status == CommandSent && now.Sub(qc.Sent) < q.responseTimeout() {
+			continue
+		}
+		if qc.Attempts >= q.maxAttempts() {
+			qc.Status, changed = CommandFailed, true
+			continue
+		}
+		due = append(due, qc)
+		cmds = append(cmds, qc.Command)
+	}
+	q.mu.Unlock()
+
+	written := 0
+	var werr error
+	for _, cmd := range cmds {
+		if werr = c.WriteContext(ctx, cmd.Bytes()); werr != nil {
+			break
+		}
+		written++
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for n, qc := range due[:min(written+1, len(due))] {
+		// cancelled or answered while writing
+		if qc.Status.Done() {
+			continue
+		}
+		qc.Attempts++
+		changed = true
+		if n == written {
+			qc.LastError = werr.Error()
+			err = fmt.Errorf("terminal %s, command %d, %w", terminalID, qc.ID, werr)
+			continue
+		}
+		qc.Status, qc.Sent = CommandSent, now
+	}
+	if !changed {
+		return err
+	}
+	return errors.Join(err, q.save())
+}
+
+// startFlush waits for running Flush of the device and returns channel closed by endFlush
+func (q *CommandQueue) startFlush(ctx context.Context, terminalID string) (chan struct{}, error) {
+	for {
+		q.mu.Lock()
+		running, ok := q.flushing[terminalID]
+		if !ok {
+			if q.flushing == nil {
+				q.flushing = make(map[string]chan struct{})
+			}
+			done := make(chan struct{})
+			q.flushing[terminalID] = done
+			q.mu.Unlock()
+			return done, nil
+		}
+		q.mu.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *CommandQueue) endFlush(terminalID string, done chan struct{}) {
+	q.mu.Lock()
+	delete(q.flushing, terminalID)
+	q.mu.Unlock()
+	close(done)
+}
+
+// save passes queue to the store, it has to be called with q.mu held
+func (q *CommandQueue) save() error {
+	if q.Store == nil {
+		return nil
+	}
+	cmds := make([]QueuedCommand, len(q.cmds))
+	for n, qc := range q.cmds {
+		cmds[n] = *qc
+	}
+	if err := q.Store.Save(cmds); err != nil {
+		return fmt.Errorf("saving command queue, %w", err)
+	}
+	return nil
+}
+
+func (q *CommandQueue) responseTimeout() time.Duration {
+	if q.ResponseTimeout > 0 {
+		return q.ResponseTimeout
+	}
+	return time.Minute
+}
+
+func (q *CommandQueue) maxAttempts() int {
+	if q.MaxAttempts > 0 {
+		return q.MaxAttempts
+	}
+	return 3
+}
+
+func (q *CommandQueue) now() time.Time {
+	if q.Now != nil {
+		return q.Now()
+	}
+	return time.Now()
+}
+
+func (q *CommandQueue) logf(format string, v ...any) {
+	if q.ErrorLog != nil {
+		q.ErrorLog.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a manually advanced time source
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2020, 7, 15, 16, 43, 28, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func readCommand(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString(')')
+	assert.NoError(t, err)
+	return line
+}
+
+func TestCommandQueue(t *testing.T) {
+	q, err := NewCommandQueue(&MemoryQueueStore{})
+	assert.NoError(t, err)
+	clk := newClock()
+	q.Now = clk.Now
+	q.TTL = time.Hour
+	q.ErrorLog = log.New(io.Discard, "", 0)
+
+	ctx := context.Background()
+	p01, _ := q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P01"))
+	p15, _ := q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P15"))
+	ota, _ := q.Enqueue(ctx, "8000620011", jointechparser.QueryUpgrade("8000620011"))
+	other, _ := q.Enqueue(ctx, "8130630001", jointechparser.NewCommand("P01"))
+	cmd, ok := q.Command(p01)
+	assert.True(t, ok)
+	assert.Equal(t, CommandQueued, cmd.Status)
+	assert.Equal(t, clk.Now().Add(time.Hour), cmd.Expires)
+
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: q.Wrap(rec)})
+	c := dial(t, addr)
+	r := bufio.NewReader(c)
+
+	// the device wakes up and heartbeats, queued commands are flushed in order
+	c.Write([]byte(heartbeat))
+	assert.Equal(t, "(P01)", readCommand(t, r))
+	assert.Equal(t, "(P15)", readCommand(t, r))
+	assert.Equal(t, "(8000620011,1,001,OTA,9,0)", readCommand(t, r))
+	rec.wait(t, 1)
+
+	c.Write([]byte("(8000620011,P22,2)(8000620011,P01,JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7,41%)"))
+	c.Write([]byte("(8000620011,1,001,OTA,9,4)"))
+	rec.wait(t, 3)
+
+	cmds := q.Commands("8000620011")
+	assert.Len(t, cmds, 3)
+	assert.Equal(t, CommandAnswered, cmds[0].Status)
+	assert.Equal(t, "P01", cmds[0].Response.Word)
+	assert.Equal(t, 1, cmds[0].Attempts)
+	assert.Equal(t, CommandSent, cmds[1].Status)
+	assert.Equal(t, ota, cmds[2].ID)
+	assert.Equal(t, CommandAnswered, cmds[2].Status)
+	assert.Nil(t, cmds[2].Response)
+	cmd, _ = q.Command(other)
+	assert.Equal(t, CommandQueued, cmd.Status)
+
+	// unanswered command is written again after response timeout until attempts run out
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+	for _, attempts := range []int{2, 3} {
+		clk.Add(time.Minute)
+		c.Write([]byte(heartbeat))
+		assert.Equal(t, "(P15)", readCommand(t, r))
+		rec.wait(t, 1)
+		cmd, _ = q.Command(p15)
+		assert.Equal(t, attempts, cmd.Attempts)
+	}
+	clk.Add(time.Minute)
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+	cmd, _ = q.Command(p15)
+	assert.Equal(t, CommandFailed, cmd.Status)
+
+	// commands expire when the device does not report in time
+	clk.Add(time.Hour)
+	assert.NoError(t, q.Flush(ctx, "8130630001", nil))
+	cmd, _ = q.Command(other)
+	assert.Equal(t, CommandExpired, cmd.Status)
+	assert.Equal(t, "Expired", cmd.Status.String())
+}
+
+func TestCommandQueueRegistry(t *testing.T) {
+	q, _ := NewCommandQueue(&MemoryQueueStore{})
+	reg := NewRegistry()
+	q.Registry = reg
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: reg.Wrap(q.Wrap(rec))})
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+
+	// connected device gets the command without waiting for its next packet
+	id, err := q.Enqueue(context.Background(), "8000620011", jointechparser.NewCommand("P15"))
+	assert.NoError(t, err)
+	assert.Equal(t, "(P15)", readCommand(t, bufio.NewReader(c)))
+	cmd, _ := q.Command(id)
+	assert.Equal(t, CommandSent, cmd.Status)
+}
+
+func TestCommandQueueConcurrentFlush(t *testing.T) {
+	q, _ := NewCommandQueue(&MemoryQueueStore{})
+	reg := NewRegistry()
+	q.Registry = reg
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: reg.Wrap(q.Wrap(rec))})
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+
+	// packets of the device flush the queue while commands are enqueued
+	const n, enqueuers = 50, 4
+	var wg sync.WaitGroup
+	wg.Add(1 + enqueuers)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			c.Write([]byte(heartbeat))
+		}
+	}()
+	for e := 0; e < enqueuers; e++ {
+		go func(e int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				_, err := q.Enqueue(context.Background(), "8000620011", jointechparser.NewCommand("P15", strconv.Itoa(e*n+i)))
+				assert.NoError(t, err)
+			}
+		}(e)
+	}
+	wg.Wait()
+	rec.wait(t, n)
+
+	// every command is written once
+	r := bufio.NewReader(c)
+	written := make(map[string]int)
+	for i := 0; i < n*enqueuers; i++ {
+		written[readCommand(t, r)]++
+	}
+	assert.Len(t, written, n*enqueuers)
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := r.ReadByte()
+	assert.Error(t, err, "command written twice")
+	for _, cmd := range q.Commands("8000620011") {
+		assert.Equal(t, 1, cmd.Attempts)
+	}
+}
+
+func TestCommandQueueWriteError(t *testing.T) {
+	q, _ := NewCommandQueue(&MemoryQueueStore{})
+	var flushErr error
+	got := make(chan struct{})
+	addr := start(t, &Server{Handler: HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		c.Close()
+		flushErr = q.Flush(ctx, "8000620011", c)
+		close(got)
+	})})
+	id, _ := q.Enqueue(context.Background(), "8000620011", jointechparser.NewCommand("P15"))
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	<-got
+
+	assert.Error(t, flushErr)
+	cmd, _ := q.Command(id)
+	assert.Equal(t, CommandQueued, cmd.Status)
+	assert.Equal(t, 1, cmd.Attempts)
+	assert.NotEmpty(t, cmd.LastError)
+}
+
+// countingStore counts saves of the queue
+type countingStore struct {
+	MemoryQueueStore
+	saves int
+}
+
+func (s *countingStore) Save(cmds []QueuedCommand) error {
+	s.saves++
+	return s.MemoryQueueStore.Save(cmds)
+}
+
+func TestCommandQueueSavesChanges(t *testing.T) {
+	store := &countingStore{}
+	q, _ := NewCommandQueue(store)
+	clk := newClock()
+	q.Now = clk.Now
+	q.TTL = time.Hour
+	ctx := context.Background()
+	id, _ := q.Enqueue(ctx, "8130630001", jointechparser.NewCommand("P01"))
+	assert.Equal(t, 1, store.saves)
+
+	// flush without due commands does not rewrite the store
+	assert.NoError(t, q.Flush(ctx, "8000620011", nil))
+	assert.Equal(t, 1, store.saves)
+
+	clk.Add(time.Hour)
+	assert.NoError(t, q.Flush(ctx, "8130630001", nil))
+	assert.Equal(t, 2, store.saves)
+	assert.NoError(t, q.Flush(ctx, "8130630001", nil))
+	assert.Equal(t, 2, store.saves)
+	cmd, _ := q.Command(id)
+	assert.Equal(t, CommandExpired, cmd.Status)
+}
+
+func TestCommandQueueCancelPrune(t *testing.T) {
+	q, _ := NewCommandQueue(&MemoryQueueStore{})
+	clk := newClock()
+	q.Now = clk.Now
+	ctx := context.Background()
+	p01, _ := q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P01"))
+	p15, _ := q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P15"))
+
+	assert.NoError(t, q.Cancel(p01))
+	assert.Error(t, q.Cancel(p01))
+	assert.Error(t, q.Cancel(100))
+
+	n, err := q.Prune(clk.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = q.Prune(clk.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	cmds := q.Commands("8000620011")
+	assert.Len(t, cmds, 1)
+	assert.Equal(t, p15, cmds[0].ID)
+}
+
+func TestFileQueueStore(t *testing.T) {
+	store := FileQueueStore{Path: filepath.Join(t.TempDir(), "queue.json")}
+	q, err := NewCommandQueue(store)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P01"))
+	q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P06", "0"))
+
+	// queue survives restart and keeps numbering
+	q, err = NewCommandQueue(store)
+	assert.NoError(t, err)
+	cmds := q.Commands("8000620011")
+	assert.Len(t, cmds, 2)
+	assert.Equal(t, jointechparser.NewCommand("P06", "0"), cmds[1].Command)
+	assert.Equal(t, CommandQueued, cmds[1].Status)
+	id, _ := q.Enqueue(ctx, "8000620011", jointechparser.NewCommand("P15"))
+	assert.Equal(t, uint64(3), id)
+
+	_, err = NewCommandQueue(FileQueueStore{Path: filepath.Join(t.TempDir(), "missing", "queue.json")})
+	assert.NoError(t, err)
+}
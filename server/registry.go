@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// ErrOffline is returned by SendCommand when the device has no open connection
+var ErrOffline = errors.New("device is offline")
+
+// Session binds a connection to the device reporting on it
+type Session struct {
+	TerminalID string
+	IMEI       string // Empty until the device sends position data
+	Conn       *Conn
+	Connected  time.Time
+	LastSeen   time.Time // Time of the last decoded packet
+}
+
+// Registry keeps sessions of connected devices keyed by TerminalID
+type Registry struct {
+	mu     sync.Mutex
+	byID   map[string]*Session
+	byConn map[*Conn]*Session
+}
+
+// NewRegistry returns empty registry
+func NewRegistry() *Registry {
+	return &Registry{byID: make(map[string]*Session), byConn: make(map[*Conn]*Session)}
+}
+
+// Wrap returns handler binding connections to devices before passing packets to next. A connection is bound by the
+// first packet carrying TerminalID, a device connecting again replaces its stale session, which is closed
+func (r *Registry) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		r.bind(c, d)
+		next.Handle(ctx, c, d)
+	})
+}
+
+func (r *Registry) bind(c *Conn, d jointechparser.Decoded) {
+	id := TerminalID(d)
+	now := time.Now()
+
+	r.mu.Lock()
+	s, ok := r.byConn[c]
+	if ok {
+		if s.IMEI == "" {
+			s.IMEI = d.IMEI
+		}
+		s.LastSeen = now
+		r.mu.Unlock()
+		return
+	}
+	if id == "" {
+		r.mu.Unlock()
+		return
+	}
+	s = &Session{TerminalID: id, IMEI: d.IMEI, Conn: c, Connected: now, LastSeen: now}
+	stale := r.byID[id]
+	if stale != nil {
+		delete(r.byConn, stale.Conn)
+	}
+	r.byID[id] = s
+	r.byConn[c] = s
+	r.mu.Unlock()
+
+	if stale != nil {
+		stale.Conn.Close()
+	}
+	context.AfterFunc(c.Context(), func() { r.unbind(s) })
+}
+
+func (r *Registry) unbind(s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byConn, s.Conn)
+	if r.byID[s.TerminalID] == s {
+		delete(r.byID, s.TerminalID)
+	}
+}
+
+// Session returns a copy of session of the device
+func (r *Registry) Session(terminalID string) (Session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.byID[terminalID]
+	if !ok {
+		return Session{}, false
+	}
+	return *s, true
+}
+
+// SessionOf returns a copy of session bound to the connection
+func (r *Registry) SessionOf(c *Conn) (Session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.byConn[c]
+	if !ok {
+		return Session{}, false
+	}
+	return *s, true
+}
+
+// Sessions returns copies of all sessions
+func (r *Registry) Sessions() []Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ss := make([]Session, 0, len(r.byID))
+	for _, s := range r.byID {
+		ss = append(ss, *s)
+	}
+	return ss
+}
+
+// SendCommand writes command to the connection the device last reported on
+func (r *Registry) SendCommand(ctx context.Context, terminalID string, cmd jointechparser.Command) error {
+	s, ok := r.Session(terminalID)
+	if !ok {
+		return fmt.Errorf("terminal %s, %w", terminalID, ErrOffline)
+	}
+	if err := s.Conn.WriteContext(ctx, cmd.Bytes()); err != nil {
+		return fmt.Errorf("terminal %s, %w", terminalID, err)
+	}
+	return nil
+}
+
+// TerminalID returns TerminalID of decoded packet, ASCII packets carry it in their records only
+func TerminalID(d jointechparser.Decoded) string {
+	switch {
+	case d.TerminalID != "":
+		return d.TerminalID
+	case len(d.Responses) > 0:
+		return d.Responses[0].TerminalID
+	case len(d.LockReports) > 0:
+		return d.LockReports[0].TerminalID
+	case len(d.OTAReplies) > 0:
+		return d.OTAReplies[0].TerminalID
+	case len(d.Peripherals) > 0:
+		return d.Peripherals[0].TerminalID
+	}
+	return ""
+}
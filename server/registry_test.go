@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: reg.Wrap(rec)})
+
+	// command response binds the connection, IMEI is added by position data
+	c := dial(t, addr)
+	c.Write([]byte("(8000620011,P15)"))
+	rec.wait(t, 1)
+	s, ok := reg.Session("8000620011")
+	assert.True(t, ok)
+	assert.Equal(t, "", s.IMEI)
+	c.Write(position(t))
+	rec.wait(t, 1)
+	s, _ = reg.Session("8000620011")
+	assert.Equal(t, "868822040248195", s.IMEI)
+	assert.Len(t, reg.Sessions(), 1)
+
+	cmd := jointechparser.NewCommand("P69", "0", "24")
+	assert.NoError(t, reg.SendCommand(context.Background(), "8000620011", cmd))
+	line, err := bufio.NewReader(c).ReadString(')')
+	assert.NoError(t, err)
+	assert.Equal(t, "(P69,0,24)", line)
+
+	err = reg.SendCommand(context.Background(), "8130630001", cmd)
+	assert.True(t, errors.Is(err, ErrOffline))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, errors.Unwrap(reg.SendCommand(ctx, "8000620011", cmd)))
+
+	// reconnect replaces and closes the stale session
+	again := dial(t, addr)
+	again.Write([]byte(heartbeat))
+	rec.wait(t, 1)
+	assert.True(t, closed(c))
+	s, _ = reg.Session("8000620011")
+	assert.Equal(t, again.LocalAddr().String(), s.Conn.RemoteAddr().String())
+	assert.Equal(t, "", s.IMEI)
+	got, ok := reg.SessionOf(s.Conn)
+	assert.True(t, ok)
+	assert.Equal(t, s, got)
+
+	// closing the connection takes the device offline
+	again.Close()
+	assert.Eventually(t, func() bool {
+		_, ok := reg.Session("8000620011")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, reg.Sessions())
+}
+
+func TestTerminalID(t *testing.T) {
+	assert.Equal(t, "", TerminalID(jointechparser.Decoded{}))
+	assert.Equal(t, "8000620011", TerminalID(jointechparser.Decoded{TerminalID: "8000620011"}))
+	assert.Equal(t, "8130630001", TerminalID(jointechparser.Decoded{OTAReplies: []jointechparser.OTAReply{{TerminalID: "8130630001"}}}))
+	assert.Equal(t, "8130630001", TerminalID(jointechparser.Decoded{Peripherals: []jointechparser.PeripheralData{{TerminalID: "8130630001"}}}))
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// Middleware wraps handler, e.g. Registry.Wrap or AutoReply.Wrap
+type Middleware func(next Handler) Handler
+
+// Chain wraps h with middlewares, the first one is the outermost
+func Chain(h Handler, mws ...Middleware) Handler {
+	for n := len(mws) - 1; n >= 0; n-- {
+		h = mws[n](h)
+	}
+	return h
+}
+
+// TypedHandler handles a single message of given type
+type TypedHandler[T any] interface {
+	Handle(ctx context.Context, dev *Device, msg T)
+}
+
+// TypedHandlerFunc is an adapter allowing use of ordinary functions as TypedHandler
+type TypedHandlerFunc[T any] func(ctx context.Context, dev *Device, msg T)
+
+// Handle calls f(ctx, dev, msg)
+func (f TypedHandlerFunc[T]) Handle(ctx context.Context, dev *Device, msg T) {
+	f(ctx, dev, msg)
+}
+
+// Heartbeat is (TerminalID,@JT) heartbeat packet
+type Heartbeat struct {
+	TerminalID string
+}
+
+// TimeSyncRequest is (TerminalID,P22,2) time synchronization request
+type TimeSyncRequest struct {
+	TerminalID string
+}
+
+// Device is a per device context kept by Router across connections
+type Device struct {
+	TerminalID string
+
+	mu     sync.Mutex
+	conn   *Conn
+	values map[any]any
+}
+
+// Conn returns the connection the device last reported on
+func (d *Device) Conn() *Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conn
+}
+
+// Value returns value stored for the device under key
+func (d *Device) Value(key any) any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.values[key]
+}
+
+// SetValue stores value for the device under key
+func (d *Device) SetValue(key, value any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.values == nil {
+		d.values = make(map[any]any)
+	}
+	d.values[key] = value
+}
+
+// Router dispatches decoded packets to handlers registered per message kind. Handlers and middlewares
+// have to be registered before the router starts handling packets
+type Router struct {
+	position        TypedHandler[jointechparser.PALData]
+	alarm           TypedHandler[jointechparser.PALData]
+	blindArea       TypedHandler[jointechparser.PALData]
+	heartbeat       TypedHandler[Heartbeat]
+	commandResponse TypedHandler[jointechparser.Response]
+	timeSync        TypedHandler[TimeSyncRequest]
+	lockReport      TypedHandler[jointechparser.LockReport]
+	peripheral      TypedHandler[jointechparser.PeripheralData]
+	otaReply        TypedHandler[jointechparser.OTAReply]
+	middlewares     []Middleware
+	handler         Handler
+	once            sync.Once
+
+	mu      sync.Mutex
+	devices map[string]*Device
+}
+
+// NewRouter returns router without handlers
+func NewRouter() *Router {
+	return &Router{devices: make(map[string]*Device)}
+}
+
+// Use appends middlewares run before dispatching, the first one is the outermost
+func (r *Router) Use(mws ...Middleware) {
+	r.middlewares = append(r.middlewares, mws...)
+}
+
+// Position registers handler of real-time and sub-new position data
+func (r *Router) Position(h TypedHandler[jointechparser.PALData]) { r.position = h }
+
+// Alarm registers handler of alarm data
+func (r *Router) Alarm(h TypedHandler[jointechparser.PALData]) { r.alarm = h }
+
+// BlindArea registers handler of position data cached while the device was out of coverage
+func (r *Router) BlindArea(h TypedHandler[jointechparser.PALData]) { r.blindArea = h }
+
+// Heartbeat registers handler of heartbeat packets
+func (r *Router) Heartbeat(h TypedHandler[Heartbeat]) { r.heartbeat = h }
+
+// CommandResponse registers handler of ASCII command responses other than time synchronization requests
+func (r *Router) CommandResponse(h TypedHandler[jointechparser.Response]) { r.commandResponse = h }
+
+// TimeSync registers handler of P22,2 time synchronization requests
+func (r *Router) TimeSync(h TypedHandler[TimeSyncRequest]) { r.timeSync = h }
+
+// LockReport registers handler of P45 lock and unlock reports
+func (r *Router) LockReport(h TypedHandler[jointechparser.LockReport]) { r.lockReport = h }
+
+// Peripheral registers handler of WLNET,5 peripheral data
+func (r *Router) Peripheral(h TypedHandler[jointechparser.PeripheralData]) { r.peripheral = h }
+
+// OTAReply registers handler of OTA-9 responses
+func (r *Router) OTAReply(h TypedHandler[jointechparser.OTAReply]) { r.otaReply = h }
+
+// Handle implements Handler, the packet passes middlewares and is dispatched to registered handlers
+func (r *Router) Handle(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+	r.once.Do(func() {
+		r.handler = Chain(HandlerFunc(r.dispatch), r.middlewares...)
+	})
+	r.handler.Handle(ctx, c, d)
+}
+
+// Device returns context of the device, nil when the device never reported
+func (r *Router) Device(terminalID string) *Device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.devices[terminalID]
+}
+
+// device returns context of the device creating it on the first packet
+func (r *Router) device(terminalID string, c *Conn) *Device {
+	r.mu.Lock()
+	dev, ok := r.devices[terminalID]
+	if !ok {
+		dev = &Device{TerminalID: terminalID}
+		if r.devices == nil {
+			r.devices = make(map[string]*Device)
+		}
+		r.devices[terminalID] = dev
+	}
+	r.mu.Unlock()
+
+	dev.mu.Lock()
+	dev.conn = c
+	dev.mu.Unlock()
+	return dev
+}
+
+func (r *Router) dispatch(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+	if d.ContainsHealthcheck && r.heartbeat != nil {
+		r.heartbeat.Handle(ctx, r.device(d.TerminalID, c), Heartbeat{TerminalID: d.TerminalID})
+	}
+	for _, p := range d.Data {
+		var h TypedHandler[jointechparser.PALData]
+		switch d.DataType {
+		case jointechparser.AlarmData:
+			h = r.alarm
+		case jointechparser.BlindAreaPosition:
+			h = r.blindArea
+		default:
+			h = r.position
+		}
+		if h != nil {
+			h.Handle(ctx, r.device(d.TerminalID, c), p)
+		}
+	}
+	for _, resp := range d.Responses {
+		if jointechparser.IsTimeSyncRequest(resp) {
+			if r.timeSync != nil {
+				r.timeSync.Handle(ctx, r.device(resp.TerminalID, c), TimeSyncRequest{TerminalID: resp.TerminalID})
+			}
+			continue
+		}
+		if r.commandResponse != nil {
+			r.commandResponse.Handle(ctx, r.device(resp.TerminalID, c), resp)
+		}
+	}
+	if r.lockReport != nil {
+		for _, lr := range d.LockReports {
+			r.lockReport.Handle(ctx, r.device(lr.TerminalID, c), lr)
+		}
+	}
+	if r.peripheral != nil {
+		for _, p := range d.Peripherals {
+			r.peripheral.Handle(ctx, r.device(p.TerminalID, c), p)
+		}
+	}
+	if r.otaReply != nil {
+		for _, o := range d.OTAReplies {
+			r.otaReply.Handle(ctx, r.device(o.TerminalID, c), o)
+		}
+	}
+}
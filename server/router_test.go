@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"testing"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// positionOfType returns position packet with given data type
+func positionOfType(t *testing.T, dataType byte) []byte {
+	bs, err := hex.DecodeString(positionHex)
+	assert.NoError(t, err)
+	bs[7] = 0x10 | dataType
+	return bs
+}
+
+func decode(t *testing.T, bs []byte) jointechparser.Decoded {
+	d, err := jointechparser.Decode(&bs)
+	assert.NoError(t, err)
+	return d
+}
+
+func TestRouter(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	record := func(s string) {
+		mu.Lock()
+		got = append(got, s)
+		mu.Unlock()
+	}
+	pal := func(kind string) TypedHandlerFunc[jointechparser.PALData] {
+		return func(ctx context.Context, dev *Device, p jointechparser.PALData) {
+			assert.Equal(t, "8000620011", dev.TerminalID)
+			record(kind)
+		}
+	}
+
+	r := NewRouter()
+	r.Position(pal("position"))
+	r.Alarm(pal("alarm"))
+	r.BlindArea(pal("blind area"))
+	r.Heartbeat(TypedHandlerFunc[Heartbeat](func(ctx context.Context, dev *Device, h Heartbeat) {
+		record("heartbeat " + h.TerminalID)
+	}))
+	r.CommandResponse(TypedHandlerFunc[jointechparser.Response](func(ctx context.Context, dev *Device, resp jointechparser.Response) {
+		record("response " + resp.Word)
+	}))
+	r.TimeSync(TypedHandlerFunc[TimeSyncRequest](func(ctx context.Context, dev *Device, req TimeSyncRequest) {
+		record("time sync")
+	}))
+	r.LockReport(TypedHandlerFunc[jointechparser.LockReport](func(ctx context.Context, dev *Device, lr jointechparser.LockReport) {
+		record("lock report " + lr.Source.String())
+	}))
+	r.Peripheral(TypedHandlerFunc[jointechparser.PeripheralData](func(ctx context.Context, dev *Device, p jointechparser.PeripheralData) {
+		record("peripheral")
+	}))
+	r.OTAReply(TypedHandlerFunc[jointechparser.OTAReply](func(ctx context.Context, dev *Device, o jointechparser.OTAReply) {
+		record("ota " + o.Status.String())
+	}))
+
+	peripheral, _ := hex.DecodeString(peripheralHex)
+	for _, bs := range [][]byte{
+		positionOfType(t, jointechparser.RealTimePosition),
+		positionOfType(t, jointechparser.AlarmData),
+		positionOfType(t, jointechparser.BlindAreaPosition),
+		positionOfType(t, jointechparser.SubNewPosition),
+		[]byte(heartbeat),
+		[]byte("(8000620011,P01,JT701D_20210311_China_Jointech_SIM7600X_LoRa_PCBV2.3_R1.2.7,41%)(8000620011,P22,2)"),
+		[]byte(lockReport),
+		peripheral,
+		[]byte("(8000620011,1,001,OTA,9,4)"),
+	} {
+		r.Handle(context.Background(), nil, decode(t, bs))
+	}
+	assert.Equal(t, []string{
+		"position", "alarm", "blind area", "position", "heartbeat 8000620011",
+		"response P01", "time sync", "lock report RFIDAuthorized", "peripheral", "ota Busy",
+	}, got)
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+				order = append(order, name)
+				next.Handle(ctx, c, d)
+			})
+		}
+	}
+	drop := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+			if !d.ContainsHealthcheck {
+				next.Handle(ctx, c, d)
+			}
+		})
+	}
+
+	r := NewRouter()
+	r.Use(mw("log"), drop, mw("auth"))
+	r.Heartbeat(TypedHandlerFunc[Heartbeat](func(ctx context.Context, dev *Device, h Heartbeat) {
+		order = append(order, "heartbeat")
+	}))
+	r.LockReport(TypedHandlerFunc[jointechparser.LockReport](func(ctx context.Context, dev *Device, lr jointechparser.LockReport) {
+		order = append(order, "lock report")
+	}))
+
+	r.Handle(context.Background(), nil, decode(t, []byte(heartbeat)))
+	r.Handle(context.Background(), nil, decode(t, []byte(lockReport)))
+	assert.Equal(t, []string{"log", "log", "auth", "lock report"}, order)
+}
+
+func TestRouterDevice(t *testing.T) {
+	type counter struct{}
+	r := NewRouter()
+	r.Heartbeat(TypedHandlerFunc[Heartbeat](func(ctx context.Context, dev *Device, h Heartbeat) {
+		n, _ := dev.Value(counter{}).(int)
+		dev.SetValue(counter{}, n+1)
+	}))
+
+	reg := NewRegistry()
+	r.Use(reg.Wrap)
+	addr := start(t, &Server{Handler: r})
+	for i := 0; i < 2; i++ {
+		c := dial(t, addr)
+		c.Write([]byte(heartbeat))
+		c.Write([]byte(heartbeat))
+		assertEventually(t, func() bool {
+			dev := r.Device("8000620011")
+			return dev != nil && dev.Value(counter{}) == 2*(i+1)
+		})
+		// device context outlives the connection and follows the device to a new one
+		s, _ := reg.Session("8000620011")
+		assert.Equal(t, s.Conn, r.Device("8000620011").Conn())
+		c.Close()
+	}
+	assert.Nil(t, r.Device("8130630001"))
+}
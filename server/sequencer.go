@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+// Gap is a run of serial numbers skipped by the device, From and To are inclusive and may wrap around 255
+type Gap struct {
+	TerminalID string
+	From       uint8
+	To         uint8
+	Count      int
+	Detected   time.Time
+}
+
+// GapReport summarizes sequence of position records of the device
+type GapReport struct {
+	TerminalID string
+	Received   int     // Records passed through the sequencer
+	Late       int     // Records which filled a gap after it was detected
+	Duplicates int     // Records with serial number already seen
+	Missing    []uint8 // Serial numbers still missing, in order they were skipped
+	Gaps       []Gap   // Detected gaps, the oldest are dropped after MaxGaps
+}
+
+// sequence is sequencing state of a single device
+type sequence struct {
+	started  bool
+	next     uint8
+	missing  map[uint8]struct{}
+	order    []uint8 // missing serial numbers in order they were skipped
+	buffered []jointechparser.PALData
+	template jointechparser.Decoded // blind-area packet the buffered records came with
+	conn     *Conn
+	report   GapReport
+}
+
+// Sequencer detects lost position records from their wrapping uint8 serial numbers and reorders blind-area
+// records. Blind-area records are buffered and released in chronological order when the device sends any other
+// packet, when MaxBuffer records are buffered or when their connection closes. A serial number skipped by real-time
+// data and received later, e.g. in blind-area data, is reported as late rather than missing
+type Sequencer struct {
+	MaxBuffer int       // Maximum number of buffered blind-area records, defaults to 64
+	MaxGaps   int       // Maximum number of gaps kept in report per device, defaults to 100
+	OnGap     func(Gap) // Called on every detected gap, optional
+	Now       func() time.Time
+
+	mu      sync.Mutex
+	devices map[string]*sequence
+}
+
+// Wrap returns handler passing packets with records in sequence to next. Buffered blind-area records are passed
+// as a separate packet of DataType BlindAreaPosition before the packet which released them. It has to run after
+// AutoReply and CommandQueue so that blind-area records are acknowledged when received rather than when released.
+// Records released because their connection closed are passed with nil Conn, next must not write to it
+func (s *Sequencer) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		id := TerminalID(d)
+		if id == "" {
+			next.Handle(ctx, c, d)
+			return
+		}
+		if len(d.Data) > 0 && d.DataType == jointechparser.BlindAreaPosition {
+			released, ok, watch := s.buffer(id, c, d)
+			if watch {
+				context.AfterFunc(c.Context(), func() {
+					if released, ok := s.Flush(id); ok {
+						next.Handle(context.Background(), nil, released)
+					}
+				})
+			}
+			if ok {
+				next.Handle(ctx, c, released)
+			}
+			return
+		}
+		if released, ok := s.Flush(id); ok {
+			next.Handle(ctx, c, released)
+		}
+		s.Observe(id, d.Data...)
+		next.Handle(ctx, c, d)
+	})
+}
+
+// buffer adds blind-area records, it returns released packet when the buffer is full and whether the connection
+// has to be watched for closing
+func (s *Sequencer) buffer(id string, c *Conn, d jointechparser.Decoded) (released jointechparser.Decoded, ok, watch bool) {
+	s.mu.Lock()
+	seq := s.sequence(id)
+	if len(seq.buffered) == 0 {
+		seq.template = d
+	}
+	seq.buffered = append(seq.buffered, d.Data...)
+	watch = c != nil && seq.conn != c
+	seq.conn = c
+	full := len(seq.buffered) >= s.maxBuffer()
+	s.mu.Unlock()
+
+	if full {
+		released, ok = s.Flush(id)
+	}
+	return released, ok, watch
+}
+
+// Flush releases buffered blind-area records of the device sorted by time, it returns false when none are buffered
+func (s *Sequencer) Flush(terminalID string) (jointechparser.Decoded, bool) {
+	s.mu.Lock()
+	seq, ok := s.devices[terminalID]
+	if !ok || len(seq.buffered) == 0 {
+		s.mu.Unlock()
+		return jointechparser.Decoded{}, false
+	}
+	d := seq.template
+	d.Data = seq.buffered
+	seq.buffered, seq.template = nil, jointechparser.Decoded{}
+	s.mu.Unlock()
+
+	sort.SliceStable(d.Data, func(i, j int) bool { return recordTime(d.Data[i]).Before(recordTime(d.Data[j])) })
+	s.Observe(terminalID, d.Data...)
+	return d, true
+}
+
+// recordTime returns time of the record, records with invalid Date or Time sort first
+func recordTime(p jointechparser.PALData) time.Time {
+	t, _ := p.Timestamp()
+	return t
+}
+
+// Observe checks serial numbers of records in the order they are passed on
+func (s *Sequencer) Observe(terminalID string, records ...jointechparser.PALData) {
+	now := s.now()
+	var gaps []Gap
+
+	s.mu.Lock()
+	seq := s.sequence(terminalID)
+	for _, p := range records {
+		seq.report.Received++
+		sn := p.SerialNo
+		if !seq.started {
+			seq.started, seq.next = true, sn+1
+			continue
+		}
+		ahead := sn - seq.next
+		switch {
+		case ahead == 0:
+			delete(seq.missing, sn)
+		case ahead < 128:
+			g := Gap{TerminalID: terminalID, From: seq.next, To: sn - 1, Count: int(ahead), Detected: now}
+			seq.compact()
+			for n := seq.next; n != sn; n++ {
+				if _, ok := seq.missing[n]; !ok {
+					seq.order = append(seq.order, n)
+				}
+				seq.missing[n] = struct{}{}
+			}
+			delete(seq.missing, sn)
+			seq.report.Gaps = append(seq.report.Gaps, g)
+			if over := len(seq.report.Gaps) - s.maxGaps(); over > 0 {
+				seq.report.Gaps = append(seq.report.Gaps[:0], seq.report.Gaps[over:]...)
+			}
+			gaps = append(gaps, g)
+		default:
+			// behind the sequence, either late record filling a gap or a record seen before
+			if _, ok := seq.missing[sn]; ok {
+				delete(seq.missing, sn)
+				seq.report.Late++
+			} else {
+				seq.report.Duplicates++
+			}
+			continue
+		}
+		seq.next = sn + 1
+	}
+	seq.compact()
+	s.mu.Unlock()
+
+	if s.OnGap != nil {
+		for _, g := range gaps {
+			s.OnGap(g)
+		}
+	}
+}
+
+// Report returns gap report of the device
+func (s *Sequencer) Report(terminalID string) GapReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq, ok := s.devices[terminalID]
+	if !ok {
+		return GapReport{TerminalID: terminalID}
+	}
+	r := seq.report
+	r.Gaps = append([]Gap(nil), r.Gaps...)
+	r.Missing = append([]uint8(nil), seq.order...)
+	return r
+}
+
+// compact drops received serial numbers from the order of missing ones
+func (seq *sequence) compact() {
+	order := seq.order[:0]
+	for _, sn := range seq.order {
+		if _, ok := seq.missing[sn]; ok {
+			order = append(order, sn)
+		}
+	}
+	seq.order = order
+}
+
+// sequence returns state of the device creating it, it has to be called with s.mu held
+func (s *Sequencer) sequence(terminalID string) *sequence {
+	seq, ok := s.devices[terminalID]
+	if !ok {
+		seq = &sequence{missing: make(map[uint8]struct{}), report: GapReport{TerminalID: terminalID}}
+		if s.devices == nil {
+			s.devices = make(map[string]*sequence)
+		}
+		s.devices[terminalID] = seq
+	}
+	return seq
+}
+
+func (s *Sequencer) maxBuffer() int {
+	if s.MaxBuffer > 0 {
+		return s.MaxBuffer
+	}
+	return 64
+}
+
+func (s *Sequencer) maxGaps() int {
+	if s.MaxGaps > 0 {
+		return s.MaxGaps
+	}
+	return 100
+}
+
+func (s *Sequencer) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
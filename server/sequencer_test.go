@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// at sets Date and Time of the record
+func at(p *jointechparser.PALData, t time.Time) {
+	p.Date, p.Time = t.Format("020106"), t.Format("150405")
+}
+
+func records(serials ...uint8) []jointechparser.PALData {
+	start := time.Date(2021, 4, 18, 16, 22, 59, 0, time.UTC)
+	ps := make([]jointechparser.PALData, len(serials))
+	for n, sn := range serials {
+		ps[n].SerialNo = sn
+		at(&ps[n], start.Add(time.Duration(n)*time.Minute))
+	}
+	return ps
+}
+
+func TestSequencerGaps(t *testing.T) {
+	var gaps []Gap
+	clk := newClock()
+	s := &Sequencer{OnGap: func(g Gap) { gaps = append(gaps, g) }, Now: clk.Now}
+
+	s.Observe("8000620011", records(10, 11, 14)...)
+	assert.Equal(t, []Gap{{TerminalID: "8000620011", From: 12, To: 13, Count: 2, Detected: clk.Now()}}, gaps)
+	s.Observe("8000620011", records(12, 11, 15)...)
+
+	r := s.Report("8000620011")
+	assert.Equal(t, 6, r.Received)
+	assert.Equal(t, 1, r.Late)
+	assert.Equal(t, 1, r.Duplicates)
+	assert.Equal(t, []uint8{13}, r.Missing)
+	assert.Len(t, r.Gaps, 1)
+
+	// serial numbers wrap around, a record more than half of the range behind is not a gap
+	s.Observe("8130630001", records(254, 255, 0, 1)...)
+	assert.Len(t, gaps, 1)
+	s.Observe("8130630001", records(3, 250, 130)...)
+	assert.Equal(t, Gap{TerminalID: "8130630001", From: 2, To: 2, Count: 1, Detected: clk.Now()}, gaps[1])
+	assert.Equal(t, Gap{TerminalID: "8130630001", From: 4, To: 129, Count: 126, Detected: clk.Now()}, gaps[2])
+	r = s.Report("8130630001")
+	assert.Equal(t, 1, r.Duplicates)
+	assert.Len(t, r.Missing, 127)
+	assert.Equal(t, uint8(2), r.Missing[0])
+	assert.Equal(t, uint8(4), r.Missing[1])
+
+	assert.Equal(t, GapReport{TerminalID: "8100620011"}, s.Report("8100620011"))
+}
+
+func TestSequencerMaxGaps(t *testing.T) {
+	s := &Sequencer{MaxGaps: 2}
+	s.Observe("8000620011", records(1, 3, 5, 7)...)
+	r := s.Report("8000620011")
+	assert.Equal(t, []uint8{2, 4, 6}, r.Missing)
+	assert.Len(t, r.Gaps, 2)
+	assert.Equal(t, uint8(4), r.Gaps[0].From)
+}
+
+func TestSequencerReorder(t *testing.T) {
+	var got []jointechparser.Decoded
+	s := &Sequencer{}
+	h := s.Wrap(HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) { got = append(got, d) }))
+	midnight := time.Date(2021, 4, 19, 0, 0, 0, 0, time.UTC)
+	blind := func(sn uint8, offset time.Duration) jointechparser.Decoded {
+		d := decode(t, positionOfType(t, jointechparser.BlindAreaPosition))
+		d.Data[0].SerialNo = sn
+		at(&d.Data[0], midnight.Add(offset))
+		return d
+	}
+
+	// blind-area records arrive newest first and are followed by real-time data, they are ordered across midnight
+	for _, d := range []jointechparser.Decoded{blind(5, time.Minute), blind(3, -time.Minute), blind(4, 0)} {
+		h.Handle(context.Background(), nil, d)
+	}
+	assert.Empty(t, got)
+	rt := decode(t, position(t))
+	rt.Data[0].SerialNo = 6
+	h.Handle(context.Background(), nil, rt)
+
+	assert.Len(t, got, 2)
+	assert.Equal(t, uint8(jointechparser.BlindAreaPosition), got[0].DataType)
+	assert.Equal(t, "8000620011", got[0].TerminalID)
+	assert.Equal(t, []uint8{3, 4, 5}, []uint8{got[0].Data[0].SerialNo, got[0].Data[1].SerialNo, got[0].Data[2].SerialNo})
+	assert.Equal(t, []string{"180421235900", "190421000000", "190421000100"},
+		[]string{got[0].Data[0].Date + got[0].Data[0].Time, got[0].Data[1].Date + got[0].Data[1].Time, got[0].Data[2].Date + got[0].Data[2].Time})
+	assert.Equal(t, rt, got[1])
+	assert.Equal(t, GapReport{TerminalID: "8000620011", Received: 4}, s.Report("8000620011"))
+
+	// full buffer is released
+	s.MaxBuffer = 2
+	h.Handle(context.Background(), nil, blind(8, 5*time.Minute))
+	h.Handle(context.Background(), nil, blind(7, 4*time.Minute))
+	assert.Len(t, got, 3)
+	assert.Len(t, got[2].Data, 2)
+	assert.Empty(t, s.Report("8000620011").Missing)
+
+	h.Handle(context.Background(), nil, decode(t, []byte(heartbeat)))
+	assert.Len(t, got, 4)
+	_, ok := s.Flush("8000620011")
+	assert.False(t, ok)
+}
+
+func TestSequencerFlushOnClose(t *testing.T) {
+	rec := newRecorder()
+	conns := make(chan *Conn, 3)
+	addr := start(t, &Server{Handler: (&Sequencer{}).Wrap(HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		conns <- c
+		rec.Handle(ctx, c, d)
+	}))})
+	c := dial(t, addr)
+	c.Write(positionOfType(t, jointechparser.BlindAreaPosition))
+	c.Write([]byte(lockReport))
+	decoded := rec.wait(t, 2)
+	assert.Len(t, decoded[0].Data, 1)
+	assert.Len(t, decoded[1].LockReports, 1)
+
+	c.Write(positionOfType(t, jointechparser.BlindAreaPosition))
+	c.Close()
+	decoded = rec.wait(t, 1)
+	assert.Len(t, decoded, 3)
+	assert.Len(t, decoded[2].Data, 1)
+
+	// records released by the closed connection are passed without it
+	assert.NotNil(t, <-conns)
+	assert.NotNil(t, <-conns)
+	assert.Nil(t, <-conns)
+}
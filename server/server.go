@@ -0,0 +1,451 @@
+// Package server implements a TCP gateway accepting JT701D connections. The byte stream of every connection is
+// split to 0x24 position packets and bracketed ASCII packets, each of them is decoded and passed to Handler.
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+)
+
+const (
+	DefaultReadTimeout  = 10 * time.Minute
+	DefaultWriteTimeout = 30 * time.Second
+	DefaultMaxFrameSize = 1024
+)
+
+// ErrServerClosed is returned by Serve after Shutdown or Close was called
+var ErrServerClosed = errors.New("server: server closed")
+
+// Handler handles a single decoded packet. ctx is cancelled when the connection is closed
+type Handler interface {
+	Handle(ctx context.Context, c *Conn, d jointechparser.Decoded)
+}
+
+// HandlerFunc is an adapter allowing use of ordinary functions as Handler
+type HandlerFunc func(ctx context.Context, c *Conn, d jointechparser.Decoded)
+
+// Handle calls f(ctx, c, d)
+func (f HandlerFunc) Handle(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+	f(ctx, c, d)
+}
+
+// Server accepts device connections and passes decoded packets to Handler
+type Server struct {
+	Handler      Handler
+	ReadTimeout  time.Duration   // Connection is closed when no complete packet arrives in this time, default 10 minutes
+	WriteTimeout time.Duration   // Deadline for writing a command to the device, default 30 seconds
+	MaxFrameSize int             // Maximum size of bracketed packet, connection is closed on longer ones, default 1024 bytes
+	ErrorLog     *log.Logger     // Logs decode and connection errors, defaults to the standard logger
+	Authorizer   Authorizer      // Validates TerminalID and IMEI of every connection before its packets are handled, optional
+	OnReject     func(Rejection) // Called when a connection is closed by Authorizer, optional
+	Limits       Limits          // Connection and packet limits, unlimited by default
+
+	mu           sync.Mutex
+	listeners    map[net.Listener]struct{}
+	conns        map[*Conn]struct{}
+	perIP        map[string]int
+	inShutdown   atomic.Bool
+	wg           sync.WaitGroup
+	metrics      metrics
+	limitersOnce sync.Once
+	rateLimiters *limiters
+}
+
+// ListenAndServe listens on TCP address addr and serves device connections
+func (s *Server) ListenAndServe(addr string) error {
+	if s.inShutdown.Load() {
+		return ErrServerClosed
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Shutdown or Close is called
+func (s *Server) Serve(l net.Listener) error {
+	if s.MaxFrameSize != 0 && s.MaxFrameSize < jointechparser.MinPositionFrameLen {
+		return fmt.Errorf("max frame size %d is smaller than position packet", s.MaxFrameSize)
+	}
+	if !s.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
+	var delay time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.inShutdown.Load() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				s.logf("server: accept error %v, retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		c := newConn(s, nc)
+		if err := s.trackConn(c, true); err != nil {
+			nc.Close()
+			if err == ErrServerClosed {
+				return err
+			}
+			continue
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.trackConn(c, false)
+			c.serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, lets handlers finish the packet they process and closes connections.
+// When ctx expires first, remaining connections are closed immediately and ctx error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	err := s.closeListeners()
+
+	// unblock reads, connections exit after their current packet is handled
+	s.mu.Lock()
+	for c := range s.conns {
+		c.nc.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes listeners and all connections
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+	err := s.closeListeners()
+	s.closeConns()
+	return err
+}
+
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.inShutdown.Load() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) trackConn(c *Conn, add bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, c)
+		if s.perIP[c.ip]--; s.perIP[c.ip] <= 0 {
+			delete(s.perIP, c.ip)
+		}
+		return nil
+	}
+	if s.inShutdown.Load() {
+		return ErrServerClosed
+	}
+	if err := s.admit(c, time.Now()); err != nil {
+		return err
+	}
+	if s.conns == nil {
+		s.conns = make(map[*Conn]struct{})
+		s.perIP = make(map[string]int)
+	}
+	s.conns[c] = struct{}{}
+	s.perIP[c.ip]++
+	s.metrics.accepted.Add(1)
+	// added under the lock so Shutdown never waits while a new connection is being added
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func (s *Server) readTimeout() time.Duration {
+	if s.ReadTimeout > 0 {
+		return s.ReadTimeout
+	}
+	return DefaultReadTimeout
+}
+
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout > 0 {
+		return s.WriteTimeout
+	}
+	return DefaultWriteTimeout
+}
+
+func (s *Server) maxFrameSize() int {
+	if s.MaxFrameSize > 0 {
+		return s.MaxFrameSize
+	}
+	return DefaultMaxFrameSize
+}
+
+// Conn is a single device connection
+type Conn struct {
+	srv    *Server
+	nc     net.Conn
+	ip     string
+	r      *bufio.Reader
+	ctx    context.Context
+	cancel context.CancelFunc
+	wmu    sync.Mutex
+	closed atomic.Bool
+
+	// terminal authorized and rate limited on the connection, accessed by the serving goroutine only
+	terminalID   string
+	imei         string
+	limitedID    string
+	frameLimited bool // read deadline of the current packet was shortened by FrameTimeout
+}
+
+func newConn(s *Server, nc net.Conn) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{srv: s, nc: nc, ip: hostOf(nc.RemoteAddr()), r: bufio.NewReader(nc), ctx: ctx, cancel: cancel}
+}
+
+// RemoteAddr returns the device address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// Context returns context cancelled when the connection is closed
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Write sends command to the device
+func (c *Conn) Write(cmd jointechparser.Command) error {
+	return c.WriteBytes(cmd.Bytes())
+}
+
+// WriteBytes sends raw data to the device
+func (c *Conn) WriteBytes(bs []byte) error {
+	return c.WriteContext(context.Background(), bs)
+}
+
+// WriteContext sends raw data to the device, the write is aborted when ctx is done
+func (c *Conn) WriteContext(ctx context.Context, bs []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	deadline := time.Now().Add(c.srv.writeTimeout())
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.nc.SetWriteDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { c.nc.SetWriteDeadline(time.Now()) })
+	defer stop()
+	_, err := c.nc.Write(bs)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.cancel()
+	return c.nc.Close()
+}
+
+func (c *Conn) serve() {
+	defer c.Close()
+	for {
+		// deadline is set before checking shutdown so a deadline set by Shutdown is never overwritten
+		deadline := time.Now().Add(c.srv.readTimeout())
+		c.nc.SetReadDeadline(deadline)
+		if c.srv.inShutdown.Load() {
+			return
+		}
+		frame, err := c.readFrame(deadline)
+		if err != nil {
+			var fe *frameError
+			if errors.As(err, &fe) {
+				c.srv.logf("server: %s %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := c.limitIP(time.Now()); err != nil {
+			c.srv.logf("server: %s %v", c.RemoteAddr(), err)
+			return
+		}
+		decoded, err := jointechparser.Decode(&frame)
+		if err != nil {
+			c.srv.logf("server: %s decode error %v", c.RemoteAddr(), err)
+			continue
+		}
+		for _, u := range decoded.Unparsed {
+			c.srv.logf("server: %s unparsed packet %q", c.RemoteAddr(), u)
+		}
+		// rejected packets do not count against limits of the terminal they claim to be from
+		if err := c.authorize(decoded); err != nil {
+			c.reject(decoded, err)
+			return
+		}
+		if id := TerminalID(decoded); id != "" {
+			if err := c.limitTerminal(id, time.Now()); err != nil {
+				c.srv.logf("server: %s %v", c.RemoteAddr(), err)
+				return
+			}
+		}
+		if c.srv.Handler != nil {
+			c.srv.Handler.Handle(c.ctx, c, decoded)
+		}
+	}
+}
+
+// frameError is a framing violation closing the connection
+type frameError struct {
+	msg string
+}
+
+func (e *frameError) Error() string {
+	return e.msg
+}
+
+// readFrame returns next 0x24 position packet or bracketed ASCII packet, bytes between packets are skipped.
+// Size of position packet is the header followed by its declared Length, invalid header closes the connection
+// deadline is the read deadline of the connection, it is shortened by FrameTimeout once a packet starts
+func (c *Conn) readFrame(deadline time.Time) ([]byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch b {
+		case 0x24:
+			c.startFrame(deadline)
+			header := make([]byte, jointechparser.PositionHeaderLen)
+			header[0] = b
+			if _, err := io.ReadFull(c.r, header[1:]); err != nil {
+				return nil, c.frameReadError(err)
+			}
+			n, err := jointechparser.PositionFrameLen(header)
+			if err != nil {
+				return nil, &frameError{err.Error()}
+			}
+			if n > c.srv.maxFrameSize() {
+				return nil, &frameError{fmt.Sprintf("packet declares %d bytes, exceeds %d bytes", n, c.srv.maxFrameSize())}
+			}
+			frame := make([]byte, n)
+			copy(frame, header)
+			if _, err := io.ReadFull(c.r, frame[len(header):]); err != nil {
+				return nil, c.frameReadError(err)
+			}
+			return frame, nil
+		case 0x28:
+			c.startFrame(deadline)
+			frame := []byte{b}
+			for {
+				b, err := c.r.ReadByte()
+				if err != nil {
+					return nil, c.frameReadError(err)
+				}
+				frame = append(frame, b)
+				if b == 0x29 {
+					return frame, nil
+				}
+				if len(frame) >= c.srv.maxFrameSize() {
+					return nil, &frameError{fmt.Sprintf("packet exceeds %d bytes", c.srv.maxFrameSize())}
+				}
+			}
+		}
+	}
+}
+
+// startFrame limits time the started packet has to be completed in
+func (c *Conn) startFrame(deadline time.Time) {
+	timeout := c.srv.Limits.FrameTimeout
+	c.frameLimited = timeout > 0 && time.Now().Add(timeout).Before(deadline)
+	if !c.frameLimited {
+		return
+	}
+	c.nc.SetReadDeadline(time.Now().Add(timeout))
+	if c.srv.inShutdown.Load() {
+		c.nc.SetReadDeadline(time.Now())
+	}
+}
+
+// frameReadError returns frameError when the packet was not completed in FrameTimeout
+func (c *Conn) frameReadError(err error) error {
+	var ne net.Error
+	if !c.frameLimited || c.srv.inShutdown.Load() || !errors.As(err, &ne) || !ne.Timeout() {
+		return err
+	}
+	c.srv.metrics.frameTimeouts.Add(1)
+	return &frameError{fmt.Sprintf("packet not completed in %v", c.srv.Limits.FrameTimeout)}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jointechparser "github.com/CliffJr/jointech-tcp-parser"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	positionHex = "2480006200111911003418042116225922348310113550543F12980000002D060000000020E028109228661F05010001" +
+		"868822040248195F" + "000001CC0156"
+	heartbeat  = "(8000620011,@JT)"
+	lockReport = "(8000620011,P45,170720,020614,22.56035,N,114.01640,E,A,36,270,1,1,0008627839,0,0,24,5)"
+	// WLNET,5 sample of the manual acknowledged by (P69,0,18)
+	peripheralHex = "28383133303633303030312C312C3131302C574C4E45542C352C322C19042111353422348344113550520F0000190421113533E0172600" +
+		"041201681057040040000000310029"
+)
+
+func position(t *testing.T) []byte {
+	bs, err := hex.DecodeString(positionHex)
+	assert.NoError(t, err)
+	assert.Len(t, bs, jointechparser.MinPositionFrameLen)
+	return bs
+}
+
+// recorder collects decoded packets passed to the handler
+type recorder struct {
+	mu      sync.Mutex
+	decoded []jointechparser.Decoded
+	got     chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{got: make(chan struct{}, 100)}
+}
+
+func (r *recorder) Handle(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+	r.mu.Lock()
+	r.decoded = append(r.decoded, d)
+	r.mu.Unlock()
+	r.got <- struct{}{}
+}
+
+func (r *recorder) wait(t *testing.T, n int) []jointechparser.Decoded {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handler called %d times, want %d", i, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]jointechparser.Decoded(nil), r.decoded...)
+}
+
+func start(t *testing.T, s *Server) string {
+	if s.ErrorLog == nil {
+		s.ErrorLog = log.New(io.Discard, "", 0)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	c, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// closed reports whether the server closed the connection
+func closed(c net.Conn) bool {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+func TestFraming(t *testing.T) {
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: rec})
+	c := dial(t, addr)
+
+	pos := position(t)
+	// packets split across writes with line breaks between them
+	stream := append([]byte(heartbeat+"\r\n"), pos...)
+	stream = append(stream, lockReport...)
+	for _, chunk := range [][]byte{stream[:5], stream[5:40], stream[40:100], stream[100:]} {
+		_, err := c.Write(chunk)
+		assert.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	decoded := rec.wait(t, 3)
+	assert.True(t, decoded[0].ContainsHealthcheck)
+	assert.Equal(t, "8000620011", decoded[0].TerminalID)
+	assert.Len(t, decoded[1].Data, 1)
+	assert.Equal(t, "868822040248195", decoded[1].IMEI)
+	assert.Len(t, decoded[2].LockReports, 1)
+}
+
+func TestFramingBackToBackPositions(t *testing.T) {
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: rec})
+	c := dial(t, addr)
+
+	// 62 byte packets of TestPacketReception with no bytes between them
+	stream, err := hex.DecodeString("2475003136201912003415072021495322349750113550364F006800000000050000000010E04F04440B321F00070F0F0F0F0F0F0F0F0F0F000001CC0002" +
+		"2475003136201911003415072021502522348802113550231F008900000000050000000000E04F04440B321F00070F0F0F0F0F0F0F0F0F0F000001CC0060")
+	assert.NoError(t, err)
+	_, err = c.Write(stream)
+	assert.NoError(t, err)
+
+	decoded := rec.wait(t, 2)
+	for i, serial := range []uint8{0x02, 0x60} {
+		assert.Equal(t, "7500313620", decoded[i].TerminalID)
+		if assert.Len(t, decoded[i].Data, 1) {
+			assert.Equal(t, serial, decoded[i].Data[0].SerialNo)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var conn *Conn
+	got := make(chan struct{})
+	addr := start(t, &Server{Handler: HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		conn = c
+		assert.NoError(t, c.Write(jointechparser.NewCommand("P69", "0", "24")))
+		close(got)
+	})})
+	c := dial(t, addr)
+	c.Write([]byte(lockReport))
+	<-got
+
+	line, err := bufio.NewReader(c).ReadString(')')
+	assert.NoError(t, err)
+	assert.Equal(t, "(P69,0,24)", line)
+	assert.Equal(t, c.LocalAddr().String(), conn.RemoteAddr().String())
+
+	conn.Close()
+	assert.Error(t, conn.Write(jointechparser.NewCommand("P15")))
+	<-conn.Context().Done()
+}
+
+func TestDecodeErrorKeepsConnection(t *testing.T) {
+	rec := newRecorder()
+	addr := start(t, &Server{Handler: rec})
+	c := dial(t, addr)
+
+	c.Write([]byte("(short)" + heartbeat))
+	decoded := rec.wait(t, 1)
+	assert.True(t, decoded[0].ContainsHealthcheck)
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	addr := start(t, &Server{Handler: newRecorder(), MaxFrameSize: 100})
+	c := dial(t, addr)
+	c.Write([]byte("(8000620011," + strings.Repeat("A", 200)))
+	assert.True(t, closed(c))
+
+	// position packet declaring Length beyond the limit
+	pos := position(t)
+	pos[8], pos[9] = 0x01, 0x00
+	c = dial(t, addr)
+	c.Write(pos)
+	assert.True(t, closed(c))
+
+	// header which is not a position packet
+	c = dial(t, addr)
+	c.Write([]byte("$GPRMC,162259.00,A,2234.8310,N"))
+	assert.True(t, closed(c))
+
+	assert.Error(t, (&Server{MaxFrameSize: 10}).Serve(nil))
+}
+
+func TestReadTimeout(t *testing.T) {
+	addr := start(t, &Server{Handler: newRecorder(), ReadTimeout: 100 * time.Millisecond})
+	c := dial(t, addr)
+	c.Write([]byte("(8000620011,"))
+	assert.True(t, closed(c))
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		close(started)
+		<-release
+	})}
+	addr := start(t, s)
+	c := dial(t, addr)
+	idle := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	<-started
+
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	// idle connection is closed while handler of the other one is still running
+	assert.True(t, closed(idle))
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned before handler finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	assert.NoError(t, <-done)
+	assert.True(t, closed(c))
+
+	_, err := net.Dial("tcp", addr)
+	assert.Error(t, err)
+	assert.Equal(t, ErrServerClosed, s.ListenAndServe("127.0.0.1:0"))
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(ctx context.Context, c *Conn, d jointechparser.Decoded) {
+		close(started)
+		select {
+		case <-ctx.Done():
+		case <-release:
+		}
+	})}
+	addr := start(t, s)
+	c := dial(t, addr)
+	c.Write([]byte(heartbeat))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	assert.True(t, closed(c))
+}
+
+func assertEventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	assert.Eventually(t, cond, 5*time.Second, 10*time.Millisecond)
+}
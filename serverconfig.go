@@ -0,0 +1,205 @@
+package jointechparser
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxServerPort   = 65530
+	maxAPNFieldLen  = 50
+	maxHostLen      = 253
+	serverConnTries = 3 // Failed attempts to main IP1 before the device switches to secondary IP2
+)
+
+// SIMSlot is the SIM card slot the device is currently working with
+type SIMSlot uint8
+
+const (
+	SIM1 SIMSlot = 1
+	SIM2 SIMSlot = 2
+)
+
+// ServerAddr is platform IP address or domain and TCP port
+type ServerAddr struct {
+	Host string
+	Port uint16
+}
+
+func (a ServerAddr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
+}
+
+// APN holds GPRS access point name and account, user and password may be blank
+type APN struct {
+	Name     string
+	User     string
+	Password string
+}
+
+// ServerConfig holds main IP1 and secondary IP2 configured by P06 together with APN of each SIM card slot.
+// IP1 and IP2 are not bound to SIM slots, APN1 belongs to SIM1 slot and APN2 to SIM2 slot. The device works
+// with a single SIM card at a time, it connects to IP1 first and after 3 failed attempts it connects to IP2.
+type ServerConfig struct {
+	Main      ServerAddr // Main IP1 set with P06,1
+	Secondary ServerAddr // Secondary IP2 set with P06,3
+	SIM1      APN        // APN of SIM1 card slot, sent together with IP1
+	SIM2      APN        // APN of SIM2 card slot, sent together with IP2
+}
+
+// ServerAttempt is a single step of the device connection plan
+type ServerAttempt struct {
+	Addr  ServerAddr
+	APN   APN
+	Tries int
+}
+
+// Attempts returns the order in which the device tries the servers when working with given SIM slot
+func (s *ServerConfig) Attempts(slot SIMSlot) []ServerAttempt {
+	apn := s.SIM1
+	if slot == SIM2 {
+		apn = s.SIM2
+	}
+	return []ServerAttempt{
+		{Addr: s.Main, APN: apn, Tries: serverConnTries},
+		{Addr: s.Secondary, APN: apn, Tries: serverConnTries},
+	}
+}
+
+// Validate checks hosts, ports and APN values
+func (s *ServerConfig) Validate() error {
+	if err := validateServer(s.Main, s.SIM1); err != nil {
+		return fmt.Errorf("main server, %v", err)
+	}
+	if err := validateServer(s.Secondary, s.SIM2); err != nil {
+		return fmt.Errorf("secondary server, %v", err)
+	}
+	return nil
+}
+
+// Commands returns P06,1 and P06,3 commands setting both servers
+func (s *ServerConfig) Commands() ([]Command, error) {
+	main, err := SetMainServer(s.Main, s.SIM1)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := SetSecondaryServer(s.Secondary, s.SIM2)
+	if err != nil {
+		return nil, err
+	}
+	return []Command{main, secondary}, nil
+}
+
+// SetMainServer returns P06,1 command setting main IP1 and APN of SIM1 slot
+func SetMainServer(addr ServerAddr, apn APN) (Command, error) {
+	if err := validateServer(addr, apn); err != nil {
+		return Command{}, err
+	}
+	return serverCommand("1", addr, apn), nil
+}
+
+// SetSecondaryServer returns P06,3 command setting secondary IP2 and APN of SIM2 slot
+func SetSecondaryServer(addr ServerAddr, apn APN) (Command, error) {
+	if err := validateServer(addr, apn); err != nil {
+		return Command{}, err
+	}
+	return serverCommand("3", addr, apn), nil
+}
+
+// QueryServerConfig returns P06,0 and P06,2 commands querying both servers
+func QueryServerConfig() []Command {
+	return []Command{NewCommand("P06", "0"), NewCommand("P06", "2")}
+}
+
+func serverCommand(mode string, addr ServerAddr, apn APN) Command {
+	return NewCommand("P06", mode, addr.Host, strconv.Itoa(int(addr.Port)), apn.Name, apn.User, apn.Password)
+}
+
+// ParseServerConfig builds ServerConfig from P06 query responses
+func ParseServerConfig(rs ...Response) (ServerConfig, error) {
+	s := ServerConfig{}
+	for _, r := range rs {
+		if err := s.Apply(r); err != nil {
+			return ServerConfig{}, err
+		}
+	}
+	return s, nil
+}
+
+// Apply updates main or secondary server with P06 response
+func (s *ServerConfig) Apply(r Response) error {
+	// (8130630001,P06,47.112.122.222,10001,internet,gprs,gprs,0)
+	if err := r.expect("P06", 6); err != nil {
+		return err
+	}
+	port, err := r.uint(1, 16)
+	if err != nil {
+		return err
+	}
+	addr := ServerAddr{Host: r.Params[0], Port: uint16(port)}
+	apn := APN{Name: r.Params[2], User: r.Params[3], Password: r.Params[4]}
+
+	which, err := r.uint(len(r.Params)-1, 8)
+	if err != nil {
+		return err
+	}
+	switch which {
+	case 0:
+		s.Main, s.SIM1 = addr, apn
+	case 1:
+		s.Secondary, s.SIM2 = addr, apn
+	default:
+		return fmt.Errorf("invalid P06 server index, want 0 or 1, got %d", which)
+	}
+	return nil
+}
+
+func validateServer(addr ServerAddr, apn APN) error {
+	if err := validateHost(addr.Host); err != nil {
+		return err
+	}
+	if addr.Port == 0 || addr.Port > maxServerPort {
+		return fmt.Errorf("invalid port, want 1-%d, got %d", maxServerPort, addr.Port)
+	}
+	if apn.Name == "" {
+		return fmt.Errorf("empty APN")
+	}
+	for _, f := range []string{apn.Name, apn.User, apn.Password} {
+		if len(f) > maxAPNFieldLen {
+			return fmt.Errorf("APN value %q is longer than %d characters", f, maxAPNFieldLen)
+		}
+		if strings.ContainsAny(f, "(),") {
+			return fmt.Errorf("APN value %q contains reserved characters", f)
+		}
+	}
+	return nil
+}
+
+// validateHost accepts IPv4 addresses and domain names
+func validateHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return fmt.Errorf("IPv6 address %s is not supported", host)
+		}
+		return nil
+	}
+	if len(host) == 0 || len(host) > maxHostLen {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	if strings.Trim(host, "0123456789.") == "" {
+		return fmt.Errorf("invalid IP address %q", host)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host %q", host)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("invalid host %q", host)
+			}
+		}
+	}
+	return nil
+}
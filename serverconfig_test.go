@@ -0,0 +1,69 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerConfigCommands(t *testing.T) {
+	s := ServerConfig{
+		Main:      ServerAddr{Host: "47.112.122.222", Port: 10001},
+		Secondary: ServerAddr{Host: "jt701.jointcontrols.com", Port: 10001},
+		SIM1:      APN{Name: "internet", User: "gprs", Password: "gprs"},
+		SIM2:      APN{Name: "CMNET"},
+	}
+	cmds, err := s.Commands()
+	assert.NoError(t, err)
+	assert.Equal(t, "(P06,1,47.112.122.222,10001,internet,gprs,gprs)", cmds[0].String())
+	assert.Equal(t, "(P06,3,jt701.jointcontrols.com,10001,CMNET,,)", cmds[1].String())
+	assert.Equal(t, "(P06,2)", QueryServerConfig()[1].String())
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	apn := APN{Name: "internet"}
+	for _, c := range []struct {
+		addr ServerAddr
+		apn  APN
+	}{
+		{ServerAddr{Host: "", Port: 10001}, apn},
+		{ServerAddr{Host: "300.1.1.1", Port: 10001}, apn},
+		{ServerAddr{Host: "::1", Port: 10001}, apn},
+		{ServerAddr{Host: "bad_host.com", Port: 10001}, apn},
+		{ServerAddr{Host: "-a.com", Port: 10001}, apn},
+		{ServerAddr{Host: "a.com", Port: 0}, apn},
+		{ServerAddr{Host: "a.com", Port: 65531}, apn},
+		{ServerAddr{Host: "a.com", Port: 10001}, APN{}},
+		{ServerAddr{Host: "a.com", Port: 10001}, APN{Name: "a,b"}},
+		{ServerAddr{Host: "a.com", Port: 10001}, APN{Name: "internet", User: string(make([]byte, 51))}},
+	} {
+		_, err := SetMainServer(c.addr, c.apn)
+		assert.Error(t, err, c)
+	}
+}
+
+func TestParseServerConfig(t *testing.T) {
+	main, _ := ParseResponse([]byte("(8130630001,P06,47.112.122.222,10001,internet,gprs,gprs,0)"))
+	secondary, _ := ParseResponse([]byte("(8130630001,P06,jt701.jointcontrols.com,10001,CMNET,,,1)"))
+	s, err := ParseServerConfig(main, secondary)
+	assert.NoError(t, err)
+	assert.Equal(t, ServerConfig{
+		Main:      ServerAddr{Host: "47.112.122.222", Port: 10001},
+		Secondary: ServerAddr{Host: "jt701.jointcontrols.com", Port: 10001},
+		SIM1:      APN{Name: "internet", User: "gprs", Password: "gprs"},
+		SIM2:      APN{Name: "CMNET"},
+	}, s)
+	assert.NoError(t, s.Validate())
+
+	attempts := s.Attempts(SIM2)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "47.112.122.222:10001", attempts[0].Addr.String())
+	assert.Equal(t, "CMNET", attempts[0].APN.Name)
+	assert.Equal(t, 3, attempts[0].Tries)
+	assert.Equal(t, s.Secondary, attempts[1].Addr)
+	assert.Equal(t, "internet", s.Attempts(SIM1)[1].APN.Name)
+
+	bad, _ := ParseResponse([]byte("(8130630001,P06,a.com,10001,CMNET,,,2)"))
+	_, err = ParseServerConfig(bad)
+	assert.Error(t, err)
+}
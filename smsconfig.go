@@ -0,0 +1,228 @@
+package jointechparser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	MaxVIPNumbers     = 5
+	maxPhoneDigits    = 20
+	minPhoneDigits    = 3
+	minAlarmTimeShift = -720
+	maxAlarmTimeShift = 780
+)
+
+// SMSConfig holds SMS related settings configured by P10, P11, P12, P23, P65 and P70 commands
+type SMSConfig struct {
+	VIPNumbers     [MaxVIPNumbers]string // VIP phone numbers with country code, e.g. +8615017935422, blank slots are not configured (P11)
+	VIPAlarms      [MaxVIPNumbers]bool   // Whether VIP number receives SMS alarms (P12)
+	SMSWakeUp      bool                  // Wake up device by SMS or phone call (P23)
+	NonVIPWakeUp   bool                  // Allow numbers which are not VIP to wake up device (P70)
+	AlarmTimeShift int16                 // Time difference of SMS alarm content from UTC in minutes [-720~780] (P10)
+	Alias          string                // Device alias used instead of device ID in SMS messages (P65)
+}
+
+// Validate checks phone numbers, alias and time difference
+func (c *SMSConfig) Validate() error {
+	for n, phone := range c.VIPNumbers {
+		if phone == "" {
+			continue
+		}
+		if err := validatePhone(phone); err != nil {
+			return fmt.Errorf("VIP%d, %v", n+1, err)
+		}
+	}
+	if c.AlarmTimeShift < minAlarmTimeShift || c.AlarmTimeShift > maxAlarmTimeShift {
+		return fmt.Errorf("invalid SMS alarm time difference, want %d-%d, got %d", minAlarmTimeShift, maxAlarmTimeShift, c.AlarmTimeShift)
+	}
+	if c.Alias != "" {
+		if err := validateAlias(c.Alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commands returns set commands for all settings, blank VIP numbers and blank alias are not sent
+func (c *SMSConfig) Commands() ([]Command, error) {
+	return smsCommands(nil, c)
+}
+
+// DiffSMSConfig returns only set commands needed to turn current settings into desired ones. The manual documents
+// no form clearing a VIP number or the alias, so removing them is an error, replace them with another value instead
+func DiffSMSConfig(current, desired SMSConfig) ([]Command, error) {
+	return smsCommands(&current, &desired)
+}
+
+// smsCommands returns set commands for settings differing from current, or all of them when current is nil
+func smsCommands(current, desired *SMSConfig) ([]Command, error) {
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+	all := current == nil
+	if all {
+		current = &SMSConfig{}
+	}
+
+	var cmds []Command
+	for n, phone := range desired.VIPNumbers {
+		if phone == current.VIPNumbers[n] {
+			continue
+		}
+		if phone == "" {
+			return nil, fmt.Errorf("VIP%d %s can not be removed, P11 has no documented clear form", n+1, current.VIPNumbers[n])
+		}
+		cmds = append(cmds, NewCommand("P11", "1", strconv.Itoa(n+1), phone))
+	}
+	if all || desired.VIPAlarms != current.VIPAlarms {
+		params := []string{"1"}
+		for _, on := range desired.VIPAlarms {
+			params = append(params, formatBool(on))
+		}
+		cmds = append(cmds, NewCommand("P12", params...))
+	}
+	if all || desired.SMSWakeUp != current.SMSWakeUp {
+		cmds = append(cmds, NewCommand("P23", "1", formatBool(desired.SMSWakeUp)))
+	}
+	if all || desired.NonVIPWakeUp != current.NonVIPWakeUp {
+		cmds = append(cmds, NewCommand("P70", "1", formatBool(desired.NonVIPWakeUp)))
+	}
+	if all || desired.AlarmTimeShift != current.AlarmTimeShift {
+		cmds = append(cmds, NewCommand("P10", "1", strconv.Itoa(int(desired.AlarmTimeShift))))
+	}
+	if desired.Alias != current.Alias {
+		if desired.Alias == "" {
+			return nil, fmt.Errorf("alias %s can not be removed, P65 has no documented clear form", current.Alias)
+		}
+		cmds = append(cmds, NewCommand("P65", "1", desired.Alias))
+	}
+	return cmds, nil
+}
+
+// QuerySMSConfig returns commands querying all settings held by SMSConfig
+func QuerySMSConfig() []Command {
+	cmds := make([]Command, 0, MaxVIPNumbers+5)
+	for n := 1; n <= MaxVIPNumbers; n++ {
+		cmds = append(cmds, NewCommand("P11", "0", strconv.Itoa(n)))
+	}
+	return append(cmds,
+		NewCommand("P12", "0"),
+		NewCommand("P23", "0"),
+		NewCommand("P70", "0"),
+		NewCommand("P10", "0"),
+		NewCommand("P65", "0"),
+	)
+}
+
+// ParseSMSConfig builds SMSConfig from query responses
+func ParseSMSConfig(rs ...Response) (SMSConfig, error) {
+	c := SMSConfig{}
+	for _, r := range rs {
+		if err := c.Apply(r); err != nil {
+			return SMSConfig{}, err
+		}
+	}
+	return c, nil
+}
+
+// Apply updates settings with a query or set response
+func (c *SMSConfig) Apply(r Response) error {
+	switch r.Word {
+	case "P11":
+		// (8130630001,P11,1,+8615017935422)
+		if err := r.expect("P11", 1); err != nil {
+			return err
+		}
+		n, err := r.uint(0, 8)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > MaxVIPNumbers {
+			return fmt.Errorf("invalid VIP index, want 1-%d, got %d", MaxVIPNumbers, n)
+		}
+		phone := ""
+		if len(r.Params) > 1 {
+			phone = r.Params[1]
+		}
+		c.VIPNumbers[n-1] = phone
+	case "P12":
+		// (8130630001,P12,1,1,0,0,0)
+		if err := r.expect("P12", MaxVIPNumbers); err != nil {
+			return err
+		}
+		for n := range c.VIPAlarms {
+			on, err := r.bool(n)
+			if err != nil {
+				return err
+			}
+			c.VIPAlarms[n] = on
+		}
+	case "P23":
+		// (8130630001,P23,1)
+		if err := r.expect("P23", 1); err != nil {
+			return err
+		}
+		on, err := r.bool(0)
+		if err != nil {
+			return err
+		}
+		c.SMSWakeUp = on
+	case "P70":
+		// (8130630001,P70,1)
+		if err := r.expect("P70", 1); err != nil {
+			return err
+		}
+		on, err := r.bool(0)
+		if err != nil {
+			return err
+		}
+		c.NonVIPWakeUp = on
+	case "P10":
+		// (8130630001,P10,480)
+		if err := r.expect("P10", 1); err != nil {
+			return err
+		}
+		shift, err := r.int(0, 16)
+		if err != nil {
+			return err
+		}
+		c.AlarmTimeShift = int16(shift)
+	case "P65":
+		// (8130630001,P65,HZBC12345)
+		if err := r.expect("P65", 1); err != nil {
+			return err
+		}
+		c.Alias = r.Params[0]
+	default:
+		return fmt.Errorf("unexpected command word %s for SMS config", r.Word)
+	}
+	return nil
+}
+
+// validatePhone accepts digits with optional leading + for country code
+func validatePhone(phone string) error {
+	digits := phone
+	if len(digits) > 0 && digits[0] == '+' {
+		digits = digits[1:]
+	}
+	if len(digits) < minPhoneDigits || len(digits) > maxPhoneDigits {
+		return fmt.Errorf("invalid phone number %q, want %d-%d digits", phone, minPhoneDigits, maxPhoneDigits)
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("invalid phone number %q, only digits are allowed", phone)
+		}
+	}
+	return nil
+}
+
+// validateAlias accepts printable ASCII except characters reserved by the command format
+func validateAlias(alias string) error {
+	for _, r := range alias {
+		if r < 0x21 || r > 0x7E || r == '(' || r == ')' || r == ',' || r == '=' {
+			return fmt.Errorf("invalid alias %q, character %q is not allowed", alias, r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSMSConfig(t *testing.T) {
+	var rs []Response
+	for _, s := range []string{
+		"(8130630001,P11,1,+8615017935422)",
+		"(8130630001,P11,5,+8613717935411)",
+		"(8130630001,P12,1,1,0,0,0)",
+		"(8130630001,P23,1)",
+		"(8130630001,P70,0)",
+		"(8130630001,P10,-240)",
+		"(8130630001,P65,HZBC12345)",
+	} {
+		r, err := ParseResponse([]byte(s))
+		assert.NoError(t, err)
+		rs = append(rs, r)
+	}
+
+	c, err := ParseSMSConfig(rs...)
+	assert.NoError(t, err)
+	expected := SMSConfig{
+		VIPNumbers:     [MaxVIPNumbers]string{"+8615017935422", "", "", "", "+8613717935411"},
+		VIPAlarms:      [MaxVIPNumbers]bool{true, true},
+		SMSWakeUp:      true,
+		AlarmTimeShift: -240,
+		Alias:          "HZBC12345",
+	}
+	assert.Equal(t, expected, c)
+
+	// round trip through set commands
+	cmds, err := c.Commands()
+	assert.NoError(t, err)
+	assert.Equal(t, "(P11,1,1,+8615017935422)", cmds[0].String())
+	assert.Equal(t, "(P12,1,1,1,0,0,0)", cmds[2].String())
+	assert.Equal(t, "(P10,1,-240)", cmds[5].String())
+	assert.Equal(t, "(P65,1,HZBC12345)", cmds[6].String())
+
+	bad, _ := ParseResponse([]byte("(8130630001,P11,6,+8615017935422)"))
+	_, err = ParseSMSConfig(bad)
+	assert.Error(t, err)
+}
+
+func TestDiffSMSConfig(t *testing.T) {
+	current := SMSConfig{VIPNumbers: [MaxVIPNumbers]string{"+8615017935422"}}
+	desired := current
+	desired.VIPNumbers[1] = "+420777123456"
+	desired.NonVIPWakeUp = true
+
+	cmds, err := DiffSMSConfig(current, desired)
+	assert.NoError(t, err)
+	assert.Equal(t, []Command{NewCommand("P11", "1", "2", "+420777123456"), NewCommand("P70", "1", "1")}, cmds)
+
+	// removing VIP number or alias has no documented command
+	current = desired
+	desired.VIPNumbers[0] = ""
+	_, err = DiffSMSConfig(current, desired)
+	assert.ErrorContains(t, err, "VIP1")
+	desired = current
+	current.Alias = "HZBC12345"
+	_, err = DiffSMSConfig(current, desired)
+	assert.ErrorContains(t, err, "alias HZBC12345")
+	cmds, _ = DiffSMSConfig(desired, desired)
+	assert.Empty(t, cmds)
+
+	for _, c := range []SMSConfig{
+		{VIPNumbers: [MaxVIPNumbers]string{"+86150a"}},
+		{VIPNumbers: [MaxVIPNumbers]string{"12"}},
+		{AlarmTimeShift: 781},
+		{Alias: "a,b"},
+		{Alias: "žluťoučký"},
+	} {
+		_, err = DiffSMSConfig(current, c)
+		assert.Error(t, err, c)
+	}
+	assert.Len(t, QuerySMSConfig(), 10)
+}
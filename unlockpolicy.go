@@ -0,0 +1,174 @@
+package jointechparser
+
+import (
+	"fmt"
+	"slices"
+)
+
+// UnlockChannels holds channels the device accepts unlocking from (P59)
+type UnlockChannels struct {
+	SMS       bool
+	GPRS      bool // Static password P43 and dynamic password P52,3 commands
+	RFID      bool // Authorized RFID cards
+	Serial    bool
+	Bluetooth bool
+}
+
+// UnlockPolicy holds unlock channels (P59), RFID unlocking associated with geofence (P58) and
+// dynamic password unlocking associated with geofence (P52,1)
+type UnlockPolicy struct {
+	Channels               UnlockChannels
+	RFIDInFence            bool    // Authorized RFID card unlocks only inside a configured geofence
+	DynamicPassword        bool    // Dynamic password unlocking is enabled
+	DynamicPasswordInFence bool    // Dynamic password unlocks only inside a configured geofence
+	Depots                 []uint8 // IDs of geofences approved for fence associated unlocking, not sent to the device
+}
+
+// DefaultUnlockPolicy returns factory default policy, all channels are enabled and unlocking is not associated with geofences
+func DefaultUnlockPolicy() UnlockPolicy {
+	return UnlockPolicy{Channels: UnlockChannels{SMS: true, GPRS: true, RFID: true, Serial: true, Bluetooth: true}}
+}
+
+// InFence reports whether any unlocking method is associated with geofences
+func (p *UnlockPolicy) InFence() bool {
+	return p.RFIDInFence || p.DynamicPasswordInFence
+}
+
+// Validate checks policy against geofences configured in the device. The device associates unlocking with every
+// enabled geofence, so when unlocking is associated with geofences each depot has to be configured and enabled
+// and no other geofence may be enabled
+func (p *UnlockPolicy) Validate(fences []Geofence) error {
+	if p.DynamicPasswordInFence && !p.DynamicPassword {
+		return fmt.Errorf("dynamic password unlocking associated with geofence requires dynamic password unlocking")
+	}
+	if !p.InFence() {
+		return nil
+	}
+	if len(p.Depots) == 0 {
+		return fmt.Errorf("unlocking is associated with geofence but no depot is given")
+	}
+	configured := make(map[uint8]*Geofence, len(fences))
+	for n := range fences {
+		configured[fences[n].ID] = &fences[n]
+	}
+	for _, id := range p.Depots {
+		g, ok := configured[id]
+		if !ok {
+			return fmt.Errorf("depot geofence %d is not configured", id)
+		}
+		if !g.Enabled {
+			return fmt.Errorf("depot geofence %d is disabled", id)
+		}
+	}
+	for _, g := range fences {
+		if g.Enabled && !slices.Contains(p.Depots, g.ID) {
+			return fmt.Errorf("geofence %d is not a depot but allows unlocking", g.ID)
+		}
+	}
+	return nil
+}
+
+// Commands returns P59, P58 and P52,1 set commands
+func (p *UnlockPolicy) Commands(fences []Geofence) ([]Command, error) {
+	if err := p.Validate(fences); err != nil {
+		return nil, err
+	}
+	return []Command{p.channelsCommand(), p.rfidCommand(), p.dynamicPasswordCommand()}, nil
+}
+
+// DiffUnlockPolicy returns only set commands needed to turn current policy into desired one
+func DiffUnlockPolicy(current, desired UnlockPolicy, fences []Geofence) ([]Command, error) {
+	if err := desired.Validate(fences); err != nil {
+		return nil, err
+	}
+	var cmds []Command
+	if current.Channels != desired.Channels {
+		cmds = append(cmds, desired.channelsCommand())
+	}
+	if current.RFIDInFence != desired.RFIDInFence {
+		cmds = append(cmds, desired.rfidCommand())
+	}
+	if current.DynamicPassword != desired.DynamicPassword || current.DynamicPasswordInFence != desired.DynamicPasswordInFence {
+		cmds = append(cmds, desired.dynamicPasswordCommand())
+	}
+	return cmds, nil
+}
+
+// QueryUnlockPolicy returns commands querying the unlock policy
+func QueryUnlockPolicy() []Command {
+	return []Command{NewCommand("P59", "0"), NewCommand("P58", "0"), NewCommand("P52", "1", "0")}
+}
+
+func (p *UnlockPolicy) channelsCommand() Command {
+	c := p.Channels
+	return NewCommand("P59", "1", formatBool(c.SMS), formatBool(c.GPRS), formatBool(c.RFID), formatBool(c.Serial), formatBool(c.Bluetooth))
+}
+
+func (p *UnlockPolicy) rfidCommand() Command {
+	return NewCommand("P58", "1", formatBool(p.RFIDInFence))
+}
+
+func (p *UnlockPolicy) dynamicPasswordCommand() Command {
+	return NewCommand("P52", "1", "1", formatBool(p.DynamicPassword), formatBool(p.DynamicPasswordInFence))
+}
+
+// ParseUnlockPolicy builds UnlockPolicy from P59, P58 and P52,1 responses, depots are left empty
+func ParseUnlockPolicy(rs ...Response) (UnlockPolicy, error) {
+	p := UnlockPolicy{}
+	for _, r := range rs {
+		if err := p.Apply(r); err != nil {
+			return UnlockPolicy{}, err
+		}
+	}
+	return p, nil
+}
+
+// Apply updates policy with P59, P58 or P52,1 response
+func (p *UnlockPolicy) Apply(r Response) error {
+	switch r.Word {
+	case "P59":
+		// (8130630001,P59,1,1,1,1,1)
+		if err := r.expect("P59", 5); err != nil {
+			return err
+		}
+		var on [5]bool
+		for n := range on {
+			v, err := r.bool(n)
+			if err != nil {
+				return err
+			}
+			on[n] = v
+		}
+		p.Channels = UnlockChannels{SMS: on[0], GPRS: on[1], RFID: on[2], Serial: on[3], Bluetooth: on[4]}
+	case "P58":
+		// (8130630001,P58,1)
+		if err := r.expect("P58", 1); err != nil {
+			return err
+		}
+		on, err := r.bool(0)
+		if err != nil {
+			return err
+		}
+		p.RFIDInFence = on
+	case "P52":
+		// (8130630001,P52,1,1,0)
+		if err := r.expect("P52", 3); err != nil {
+			return err
+		}
+		if r.Params[0] != "1" {
+			return fmt.Errorf("unexpected P52 command ID %s, want 1", r.Params[0])
+		}
+		enabled, err := r.bool(1)
+		if err != nil {
+			return err
+		}
+		inFence, err := r.bool(2)
+		if err != nil {
+			return err
+		}
+		p.DynamicPassword, p.DynamicPasswordInFence = enabled, inFence
+	default:
+		return fmt.Errorf("unexpected command word %s for unlock policy", r.Word)
+	}
+	return nil
+}
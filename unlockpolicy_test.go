@@ -0,0 +1,83 @@
+package jointechparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUnlockPolicy(t *testing.T) {
+	channels, _ := ParseResponse([]byte("(8130630001,P59,1,1,1,1,1)"))
+	rfid, _ := ParseResponse([]byte("(8130630001,P58,0)"))
+	password, _ := ParseResponse([]byte("(8130630001,P52,1,0,0)"))
+
+	p, err := ParseUnlockPolicy(channels, rfid, password)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultUnlockPolicy(), p)
+
+	rfid, _ = ParseResponse([]byte("(8130630001,P58,1)"))
+	password, _ = ParseResponse([]byte("(8130630001,P52,1,1,1)"))
+	assert.NoError(t, p.Apply(rfid))
+	assert.NoError(t, p.Apply(password))
+	assert.True(t, p.RFIDInFence)
+	assert.True(t, p.DynamicPassword)
+	assert.True(t, p.DynamicPasswordInFence)
+
+	for _, s := range []string{"(8130630001,P59,1,1,1)", "(8130630001,P52,0,000000,386531)", "(8130630001,P58,2)", "(8130630001,P40,1)"} {
+		r, _ := ParseResponse([]byte(s))
+		_, err := ParseUnlockPolicy(r)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestUnlockPolicyCommands(t *testing.T) {
+	assert.Equal(t, []Command{NewCommand("P59", "0"), NewCommand("P58", "0"), NewCommand("P52", "1", "0")}, QueryUnlockPolicy())
+
+	fences := []Geofence{{ID: 1, Name: "depot1", Enabled: true}, {ID: 2, Name: "depot2", Enabled: true}}
+	p := DefaultUnlockPolicy()
+	p.Channels.SMS = false
+	p.RFIDInFence = true
+	p.DynamicPassword = true
+	p.DynamicPasswordInFence = true
+	p.Depots = []uint8{1, 2}
+
+	cmds, err := p.Commands(fences)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"(P59,1,0,1,1,1,1)", "(P58,1,1)", "(P52,1,1,1,1)"},
+		[]string{cmds[0].String(), cmds[1].String(), cmds[2].String()})
+
+	cmds, err = DiffUnlockPolicy(DefaultUnlockPolicy(), p, fences)
+	assert.NoError(t, err)
+	assert.Len(t, cmds, 3)
+	current := p
+	current.Channels.SMS = true
+	cmds, err = DiffUnlockPolicy(current, p, fences)
+	assert.NoError(t, err)
+	assert.Equal(t, []Command{NewCommand("P59", "1", "0", "1", "1", "1", "1")}, cmds)
+
+	// policy without fence association does not need depots
+	cmds, err = DiffUnlockPolicy(DefaultUnlockPolicy(), DefaultUnlockPolicy(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, cmds)
+}
+
+func TestUnlockPolicyValidate(t *testing.T) {
+	fences := []Geofence{{ID: 1, Enabled: true}, {ID: 3, Enabled: false}}
+	for _, c := range []struct {
+		name   string
+		policy UnlockPolicy
+		fences []Geofence
+	}{
+		{"no depots", UnlockPolicy{RFIDInFence: true}, fences},
+		{"missing fence", UnlockPolicy{RFIDInFence: true, Depots: []uint8{2}}, fences},
+		{"disabled fence", UnlockPolicy{RFIDInFence: true, Depots: []uint8{1, 3}}, fences},
+		{"fence outside depots", UnlockPolicy{RFIDInFence: true, Depots: []uint8{1}}, append(fences, Geofence{ID: 4, Enabled: true})},
+		{"password disabled", UnlockPolicy{DynamicPasswordInFence: true, Depots: []uint8{1}}, fences[:1]},
+	} {
+		assert.Error(t, c.policy.Validate(c.fences), c.name)
+	}
+	p := UnlockPolicy{DynamicPassword: true, DynamicPasswordInFence: true, Depots: []uint8{1}}
+	assert.NoError(t, p.Validate(fences[:1]))
+	// disabled fence does not allow unlocking
+	assert.NoError(t, p.Validate(fences))
+}